@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/hartfordfive/n2p-script-executor/config"
 	"github.com/hartfordfive/n2p-script-executor/executor"
+	"github.com/hartfordfive/n2p-script-executor/lib"
 	"github.com/hartfordfive/n2p-script-executor/logging"
+	"github.com/hartfordfive/n2p-script-executor/rules"
 	"github.com/hartfordfive/n2p-script-executor/version"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -19,8 +25,21 @@ var (
 	FlagConfig     string
 	FlagLogLevel   string
 	FlagSimulate   bool
+	FlagInterval   time.Duration
+	FlagListenAddr string
 )
 
+// rulesOptions are the flags of the generate-rules sub-command
+var rulesOptions struct {
+	config      string
+	outputFile  string
+	logLevel    string
+	interval    time.Duration
+	staleFactor float64
+}
+
+var sandboxOptions executor.SandboxOptions
+
 var (
 	entry = &cobra.Command{
 		Use:   "n2p-script-executor",
@@ -37,7 +56,25 @@ func init() {
 	RunCmd.Flags().StringVarP(&FlagConfig, "config", "c", "", "Path to the config")
 	RunCmd.Flags().StringVarP(&FlagLogLevel, "log-level", "l", "", "Enable debug logging.")
 	RunCmd.Flags().BoolVarP(&FlagSimulate, "simulate", "s", false, "Simulate only, don't write metrics to output textfile.")
-	entry.AddCommand(RunCmd, VersionCmd)
+	RunCmd.Flags().DurationVarP(&FlagInterval, "interval", "i", 0, "Run as a daemon, executing the scripts at this interval (e.g. 60s). Runs once when not set.")
+	RunCmd.Flags().StringVar(&FlagListenAddr, "listen-address", "", "Address to serve the management API on in daemon mode (e.g. 127.0.0.1:9550). Disabled when not set.")
+	GenerateRulesCmd.Flags().StringVarP(&rulesOptions.config, "config", "c", "", "Path to the config")
+	GenerateRulesCmd.Flags().StringVarP(&rulesOptions.outputFile, "output-file", "o", "", "Path to the rule file to write. The rules are written to stdout when not set.")
+	GenerateRulesCmd.Flags().DurationVarP(&rulesOptions.interval, "interval", "i", 0, "Interval the scripts are executed at, by the daemon or by cron (e.g. 60s).")
+	GenerateRulesCmd.Flags().StringVarP(&rulesOptions.logLevel, "log-level", "l", "", "Enable debug logging.")
+	GenerateRulesCmd.Flags().Float64Var(&rulesOptions.staleFactor, "stale-factor", 3, "Number of intervals after which the last successful execution of a script is stale.")
+	GenerateRulesCmd.MarkFlagRequired("config")
+	GenerateRulesCmd.MarkFlagRequired("interval")
+	SandboxExecCmd.Flags().BoolVar(&sandboxOptions.Loopback, "loopback", false, "Bring up the loopback interface of the network namespace.")
+	SandboxExecCmd.Flags().BoolVar(&sandboxOptions.ReadOnlyRoot, "read-only-root", false, "Remount every mount as read-only.")
+	SandboxExecCmd.Flags().StringArrayVar(&sandboxOptions.WritablePaths, "writable", nil, "Path kept writable when remounting as read-only.")
+	SandboxExecCmd.Flags().BoolVar(&sandboxOptions.PrivateTmp, "private-tmp", false, "Mount a private /tmp.")
+	SandboxExecCmd.Flags().BoolVar(&sandboxOptions.MountProc, "mount-proc", false, "Mount /proc for the PID namespace.")
+	SandboxExecCmd.Flags().BoolVar(&sandboxOptions.Init, "init", false, "Stay the init process of the PID namespace, executing the command as a child.")
+	SandboxExecCmd.Flags().IntVar(&sandboxOptions.UID, "uid", -1, "User id to execute the command as.")
+	SandboxExecCmd.Flags().IntVar(&sandboxOptions.GID, "gid", -1, "Group id to execute the command as.")
+	SandboxExecCmd.Flags().IntSliceVar(&sandboxOptions.Groups, "group", nil, "Supplementary group id of the command.")
+	entry.AddCommand(RunCmd, VersionCmd, GenerateRulesCmd, SandboxExecCmd)
 }
 
 // RunCmd is used to initialize the "run" sub-command under the n2p-script-executor
@@ -52,6 +89,8 @@ var RunCmd = &cobra.Command{
 			ConfigFilePath: FlagConfig,
 			LogLevel:       FlagLogLevel,
 			Simulate:       FlagSimulate,
+			Interval:       FlagInterval,
+			ListenAddress:  FlagListenAddr,
 		})
 		os.Exit(0)
 
@@ -68,3 +107,46 @@ var VersionCmd = &cobra.Command{
 		os.Exit(0)
 	},
 }
+
+// GenerateRulesCmd is used to initialize the "generate-rules" sub-command under the n2p-script-executor
+var GenerateRulesCmd = &cobra.Command{
+	Use:   "generate-rules ",
+	Short: "Generate Prometheus alerting rules",
+	Long:  `Generates a Prometheus rule file with the alerting rules of the scripts in the config, based on their alert settings.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logging.SetLogLevel(rulesOptions.logLevel)
+		cnf, err := config.Load(rulesOptions.config)
+		if err != nil {
+			log.Errorln(err)
+			os.Exit(1)
+		}
+		content, err := rules.Generate(cnf, rulesOptions.interval, rulesOptions.staleFactor)
+		if err != nil {
+			log.Errorf("Could not generate rules: %v", err)
+			os.Exit(1)
+		}
+		if rulesOptions.outputFile == "" {
+			fmt.Print(string(content))
+			os.Exit(0)
+		}
+		if err := lib.AtomicWriteFile(rulesOptions.outputFile, string(content), 0644, -1, -1); err != nil {
+			log.Errorf("Could not write rule file %s: %v", rulesOptions.outputFile, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+}
+
+// SandboxExecCmd is used internally by the executor to set up the namespaces of a sandboxed
+// script before executing it
+var SandboxExecCmd = &cobra.Command{
+	Use:    "sandbox-exec [flags] -- command [args...]",
+	Short:  "Execute a command in a sandbox",
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := executor.SandboxExec(sandboxOptions, args)
+		fmt.Fprintf(os.Stderr, "sandbox-exec: %v\n", err)
+		os.Exit(126)
+	},
+}
@@ -5,30 +5,195 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-yaml/yaml"
 	"github.com/hartfordfive/n2p-script-executor/lib"
 )
 
+var (
+	cgroupMemoryMaxRegex = regexp.MustCompile(`^(max|[0-9]+[KMGT]?)$`)
+	cgroupCPUMaxRegex    = regexp.MustCompile(`^(max|[0-9]+)( [0-9]+)?$`)
+	// promDurationRegex matches the durations of Prometheus, which differ from Go's
+	promDurationRegex = regexp.MustCompile(`^([0-9]+y)?([0-9]+w)?([0-9]+d)?([0-9]+h)?([0-9]+m)?([0-9]+s)?([0-9]+ms)?$`)
+)
+
+// TargetPlaceholder is replaced by the target in the args of the scripts executed for a
+// target
+const TargetPlaceholder = "{{target}}"
+
 // Script is the struct describing the script to be executed
 type Script struct {
-	Name               string            `yaml:"name"`
-	Timeout            string            `yaml:"timeout"`
-	Type               string            `yaml:"type"`
-	Help               string            `yaml:"help"`
-	OutputType         string            `yaml:"output_type"`
-	Path               string            `yaml:"path"`
-	OverrideMetricName string            `yaml:"override_metric_name"`
-	Labels             map[string]string `yaml:"labels"`
-	MetricsRegex       string            `yaml:"metrics_regex"`
+	Name                string               `yaml:"name" json:"name"`
+	Timeout             string               `yaml:"timeout" json:"timeout"`
+	Type                string               `yaml:"type" json:"type"`
+	Help                string               `yaml:"help" json:"help"`
+	OutputType          string               `yaml:"output_type" json:"output_type"`
+	Path                string               `yaml:"path" json:"path"`
+	Args                []string             `yaml:"args" json:"args"`
+	Probe               bool                 `yaml:"probe" json:"probe"`
+	Targets             []string             `yaml:"targets" json:"targets"`
+	TargetFiles         []string             `yaml:"target_files" json:"target_files"`
+	OverrideMetricName  string               `yaml:"override_metric_name" json:"override_metric_name"`
+	Labels              map[string]string    `yaml:"labels" json:"labels"`
+	MetricsRegex        string               `yaml:"metrics_regex" json:"metrics_regex"`
+	Group               string               `yaml:"group" json:"group"`
+	ConcurrencyGroup    string               `yaml:"concurrency_group" json:"concurrency_group"`
+	OnFailure           string               `yaml:"on_failure" json:"on_failure"`
+	MaxStale            string               `yaml:"max_stale" json:"max_stale"`
+	SentinelValue       *float64             `yaml:"sentinel_value" json:"sentinel_value"`
+	Retries             int                  `yaml:"retries" json:"retries"`
+	RetryDelay          string               `yaml:"retry_delay" json:"retry_delay"`
+	RetryBackoff        float64              `yaml:"retry_backoff" json:"retry_backoff"`
+	Limits              Limits               `yaml:"limits" json:"limits"`
+	Nice                int                  `yaml:"nice" json:"nice"`
+	IONiceClass         string               `yaml:"ionice_class" json:"ionice_class"`
+	IONiceLevel         int                  `yaml:"ionice_level" json:"ionice_level"`
+	Cgroup              CgroupLimits         `yaml:"cgroup" json:"cgroup"`
+	User                string               `yaml:"user" json:"user"`
+	RunAsGroup          string               `yaml:"run_as_group" json:"run_as_group"`
+	SupplementaryGroups []string             `yaml:"supplementary_groups" json:"supplementary_groups"`
+	Sandbox             Sandbox              `yaml:"sandbox" json:"sandbox"`
+	MaxOutputBytes      int                  `yaml:"max_output_bytes" json:"max_output_bytes"`
+	ParseSource         string               `yaml:"parse_source" json:"parse_source"`
+	KillGrace           string               `yaml:"kill_grace" json:"kill_grace"`
+	DependsOn           []string             `yaml:"depends_on" json:"depends_on"`
+	Schedule            string               `yaml:"schedule" json:"schedule"`
+	CheckPeriod         string               `yaml:"check_period" json:"check_period"`
+	Splay               string               `yaml:"splay" json:"splay"`
+	Alert               Alert                `yaml:"alert" json:"alert"`
+	Warning             string               `yaml:"warning" json:"warning"`
+	Critical            string               `yaml:"critical" json:"critical"`
+	Thresholds          map[string]Threshold `yaml:"thresholds" json:"thresholds"`
+	// Target and TargetLabels are set on each execution of a script expanded from its
+	// targets, and aren't part of the config
+	Target       string            `yaml:"-" json:"-"`
+	TargetLabels map[string]string `yaml:"-" json:"-"`
+}
+
+// Limits is the struct describing the resource limits applied to the process of a script
+type Limits struct {
+	CPUSeconds     int `yaml:"cpu_seconds" json:"cpu_seconds"`
+	AddressSpaceMB int `yaml:"address_space_mb" json:"address_space_mb"`
+	RSSMB          int `yaml:"rss_mb" json:"rss_mb"`
+	OpenFiles      int `yaml:"open_files" json:"open_files"`
+	Processes      int `yaml:"processes" json:"processes"`
+}
+
+// Cgroups is the struct describing the cgroup v2 sub-tree the scripts are executed in
+type Cgroups struct {
+	Parent  string                  `yaml:"parent"`
+	SplitBy string                  `yaml:"split_by"`
+	Groups  map[string]CgroupLimits `yaml:"groups"`
+}
+
+// CgroupLimits is the struct describing the limits set on a cgroup
+type CgroupLimits struct {
+	MemoryMax string `yaml:"memory_max" json:"memory_max"`
+	CPUMax    string `yaml:"cpu_max" json:"cpu_max"`
+	PidsMax   int    `yaml:"pids_max" json:"pids_max"`
+}
+
+// Sandbox is the struct describing the Linux namespaces a script is confined in
+type Sandbox struct {
+	NoNetwork     bool     `yaml:"no_network" json:"no_network"`
+	ReadOnlyRoot  bool     `yaml:"read_only_root" json:"read_only_root"`
+	WritablePaths []string `yaml:"writable_paths" json:"writable_paths"`
+	PrivateTmp    bool     `yaml:"private_tmp" json:"private_tmp"`
+	PIDNamespace  bool     `yaml:"pid_namespace" json:"pid_namespace"`
+}
+
+// Enabled returns true when the script is run in at least one new namespace
+func (s Sandbox) Enabled() bool {
+	return s.NoNetwork || s.ReadOnlyRoot || s.PrivateTmp || s.PIDNamespace
+}
+
+// Threshold is the struct describing the Nagios ranges the value of a metric is checked
+// against to derive its state
+type Threshold struct {
+	Warning  string `yaml:"warning" json:"warning"`
+	Critical string `yaml:"critical" json:"critical"`
+}
+
+// IsSet returns true when at least one of the ranges is set
+func (t Threshold) IsSet() bool {
+	return t.Warning != "" || t.Critical != ""
+}
+
+// MetricThreshold returns the threshold of the metric of the script, which is the one
+// set for the metric in Thresholds, or the warning and critical ranges of the script.
+// The metrics of multi_metric scripts are named after their capture group, while the
+// metric of stdout scripts has no name.
+func (s Script) MetricThreshold(metric string) Threshold {
+	if threshold, ok := s.Thresholds[metric]; ok {
+		return threshold
+	}
+	return Threshold{Warning: s.Warning, Critical: s.Critical}
+}
+
+// Alert is the struct describing the alerting rules generated for a script
+type Alert struct {
+	Disabled   bool   `yaml:"disabled" json:"disabled"`
+	For        string `yaml:"for" json:"for"`
+	Severity   string `yaml:"severity" json:"severity"`
+	RunbookURL string `yaml:"runbook_url" json:"runbook_url"`
+	// Summary is the summary of every alert of the script, unless it's set for the alert
+	// in Summaries
+	Summary   string            `yaml:"summary" json:"summary"`
+	Summaries map[string]string `yaml:"summaries" json:"summaries"`
+}
+
+// AlertNames are the names of the alerts generated for a script
+var AlertNames = []string{"ScriptStateNotOK", "ScriptExecutionFailed", "ScriptResultStale"}
+
+// TimePeriod is the struct describing when the scripts which have it as their check period
+// are executed.  Ranges are keyed by day of the week or date, and the time periods named
+// in Exclude are removed from it.
+type TimePeriod struct {
+	Ranges  map[string]string `yaml:"ranges"`
+	Exclude []string          `yaml:"exclude"`
+}
+
+// Output is the struct describing an additional destination the resulting series are sent to.
+// Apart from the name and type, fields only apply to the output types that use them.
+type Output struct {
+	Name           string            `yaml:"name"`
+	Type           string            `yaml:"type"`
+	Protocol       string            `yaml:"protocol"`
+	Address        string            `yaml:"address"`
+	Path           string            `yaml:"path"`
+	Directory      string            `yaml:"directory"`
+	SplitBy        string            `yaml:"split_by"`
+	FileMode       string            `yaml:"file_mode"`
+	Owner          string            `yaml:"owner"`
+	Database       string            `yaml:"database"`
+	Template       string            `yaml:"template"`
+	Prefix         string            `yaml:"prefix"`
+	Timeout        string            `yaml:"timeout"`
+	ExportInterval string            `yaml:"export_interval"`
+	Tags           map[string]string `yaml:"tags"`
+	Headers        map[string]string `yaml:"headers"`
 }
 
 // Config is the struct that maps to the yaml configuration
 type Config struct {
-	SeriesPrefix string   `yaml:"series_prefix"`
-	Scripts      []Script `yaml:"scripts"`
+	SeriesPrefix      string                `yaml:"series_prefix"`
+	Scripts           []Script              `yaml:"scripts"`
+	Outputs           []Output              `yaml:"outputs"`
+	StateFile         string                `yaml:"state_file"`
+	MaxConcurrency    int                   `yaml:"max_concurrency"`
+	MaxProbes         int                   `yaml:"max_concurrent_probes"`
+	ConcurrencyGroups map[string]int        `yaml:"concurrency_groups"`
+	Cgroups           Cgroups               `yaml:"cgroups"`
+	TimePeriods       map[string]TimePeriod `yaml:"timeperiods"`
+	Splay             string                `yaml:"splay"`
 }
 
 // Load loads the yaml config from the specified file path
@@ -40,6 +205,15 @@ func Load(path string) (*Config, error) {
 		return &conf, err
 	}
 
+	// The target files are relative to the directory of the config file
+	for i := range conf.Scripts {
+		for j, pattern := range conf.Scripts[i].TargetFiles {
+			if !filepath.IsAbs(pattern) {
+				conf.Scripts[i].TargetFiles[j] = filepath.Join(filepath.Dir(path), pattern)
+			}
+		}
+	}
+
 	if err := conf.InitAndValidate(); err != nil {
 		return nil, err
 	}
@@ -56,6 +230,26 @@ func (c *Config) InitAndValidate() error {
 		return errors.New("must specify at least one script to execute")
 	}
 
+	if c.MaxConcurrency == 0 {
+		c.MaxConcurrency = 4
+	} else if c.MaxConcurrency < 0 {
+		return fmt.Errorf("max_concurrency must be >= 1 (value passed: %d)", c.MaxConcurrency)
+	}
+	if c.MaxProbes == 0 {
+		c.MaxProbes = 10
+	} else if c.MaxProbes < 0 {
+		return fmt.Errorf("max_concurrent_probes must be >= 1 (value passed: %d)", c.MaxProbes)
+	}
+	for group, limit := range c.ConcurrencyGroups {
+		if limit < 1 {
+			return fmt.Errorf("concurrency limit of group '%s' must be >= 1 (value passed: %d)", group, limit)
+		}
+	}
+
+	if d, err := time.ParseDuration(c.Splay); c.Splay != "" && (err != nil || d < 0) {
+		return fmt.Errorf("invalid splay duration string '%s'", c.Splay)
+	}
+
 	for i := range c.Scripts {
 		_, err := os.Stat(c.Scripts[i].Path)
 		if os.IsNotExist(err) {
@@ -71,12 +265,569 @@ func (c *Config) InitAndValidate() error {
 				return fmt.Errorf("invalid timeout duration string '%s' for script '%s'", c.Scripts[i].Timeout, c.Scripts[i].Path)
 			}
 			if d.Seconds() < 1 {
-				return fmt.Errorf("timeout for script '%s' must be >= 1 (value passed: %d)", c.Scripts[i].Path, c.Scripts[i].Timeout)
+				return fmt.Errorf("timeout for script '%s' must be >= 1 (value passed: %s)", c.Scripts[i].Path, c.Scripts[i].Timeout)
 			}
 		}
+		if c.Scripts[i].Splay == "" {
+			c.Scripts[i].Splay = c.Splay
+		} else if d, err := time.ParseDuration(c.Scripts[i].Splay); err != nil || d < 0 {
+			return fmt.Errorf("invalid splay duration string '%s' for script '%s'", c.Scripts[i].Splay, c.Scripts[i].Path)
+		}
+		if c.Scripts[i].KillGrace == "" {
+			c.Scripts[i].KillGrace = "5s"
+		} else if d, err := time.ParseDuration(c.Scripts[i].KillGrace); err != nil || d < 0 {
+			return fmt.Errorf("invalid kill_grace duration string '%s' for script '%s'", c.Scripts[i].KillGrace, c.Scripts[i].Path)
+		}
 		if !lib.StringIsInSlice(c.Scripts[i].OutputType, validOutputTypes) {
 			return (fmt.Errorf("Invalid script output type: %s", c.Scripts[i].OutputType))
 		}
+		if _, ok := c.ConcurrencyGroups[c.Scripts[i].ConcurrencyGroup]; c.Scripts[i].ConcurrencyGroup != "" && !ok {
+			return fmt.Errorf("concurrency_group of script '%s' is an unknown concurrency group '%s'", c.Scripts[i].Path, c.Scripts[i].ConcurrencyGroup)
+		}
+		if err := c.Scripts[i].initOutputCapture(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].initFailurePolicy(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].initRetryPolicy(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].validateLimits(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].Cgroup.validate(c.Scripts[i].Path); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].validateUser(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].Sandbox.validate(c.Scripts[i].Path); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].validateTargets(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].validateProbe(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].initAlert(); err != nil {
+			return err
+		}
+		if err := c.Scripts[i].validateThresholds(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateDependencies(); err != nil {
+		return err
+	}
+
+	if err := c.validateSchedules(); err != nil {
+		return err
+	}
+
+	if err := c.Cgroups.validate(); err != nil {
+		return err
+	}
+
+	outputNames := map[string]bool{}
+	// The split textfile outputs all write the series of the executor to the same file
+	textfileDirs := map[string]string{}
+	for i := range c.Outputs {
+		if err := c.Outputs[i].initAndValidate(); err != nil {
+			return err
+		}
+		if outputNames[c.Outputs[i].Name] {
+			return fmt.Errorf("output name '%s' is used more than once", c.Outputs[i].Name)
+		}
+		outputNames[c.Outputs[i].Name] = true
+		if c.Outputs[i].Type == "textfile" && c.Outputs[i].SplitBy != "" {
+			dir := filepath.Clean(c.Outputs[i].Directory)
+			if other, ok := textfileDirs[dir]; ok {
+				return fmt.Errorf("outputs '%s' and '%s' can't both be split in directory %s", other, c.Outputs[i].Name, dir)
+			}
+			textfileDirs[dir] = c.Outputs[i].Name
+		}
+	}
+
+	return nil
+}
+
+func (s *Script) initOutputCapture() error {
+	if s.MaxOutputBytes == 0 {
+		s.MaxOutputBytes = 1048576
+	} else if s.MaxOutputBytes < 0 {
+		return fmt.Errorf("max_output_bytes for script '%s' must be > 0 (value passed: %d)", s.Path, s.MaxOutputBytes)
+	}
+	if s.ParseSource == "" {
+		s.ParseSource = "stdout"
+	} else if s.ParseSource != "stdout" && s.ParseSource != "stderr" {
+		return fmt.Errorf("invalid parse_source '%s' for script '%s'", s.ParseSource, s.Path)
+	}
+	return nil
+}
+
+func (s *Script) initFailurePolicy() error {
+
+	validPolicies := []string{"drop", "carry_forward", "sentinel"}
+
+	if s.OnFailure == "" {
+		s.OnFailure = "drop"
+	}
+	if !lib.StringIsInSlice(s.OnFailure, validPolicies) {
+		return fmt.Errorf("Invalid on_failure policy '%s' for script '%s'", s.OnFailure, s.Path)
+	}
+
+	if s.MaxStale == "" {
+		s.MaxStale = "10m"
+	} else if d, err := time.ParseDuration(s.MaxStale); err != nil || d <= 0 {
+		return fmt.Errorf("invalid max_stale duration string '%s' for script '%s'", s.MaxStale, s.Path)
+	}
+
+	if s.SentinelValue == nil {
+		// Nagios UNKNOWN state
+		unknown := 3.0
+		s.SentinelValue = &unknown
+	}
+
+	return nil
+}
+
+func (s *Script) initRetryPolicy() error {
+
+	if s.Retries < 0 {
+		return fmt.Errorf("retries for script '%s' must be >= 0 (value passed: %d)", s.Path, s.Retries)
+	}
+
+	if s.RetryDelay == "" {
+		s.RetryDelay = "1s"
+	} else if d, err := time.ParseDuration(s.RetryDelay); err != nil || d < 0 {
+		return fmt.Errorf("invalid retry_delay duration string '%s' for script '%s'", s.RetryDelay, s.Path)
+	}
+
+	if s.RetryBackoff == 0 {
+		s.RetryBackoff = 1
+	} else if s.RetryBackoff < 1 {
+		return fmt.Errorf("retry_backoff for script '%s' must be >= 1 (value passed: %v)", s.Path, s.RetryBackoff)
+	}
+
+	return nil
+}
+
+func (s *Script) validateLimits() error {
+
+	validIONiceClasses := []string{"", "realtime", "best-effort", "idle"}
+
+	l := s.Limits
+	if l.CPUSeconds < 0 || l.AddressSpaceMB < 0 || l.RSSMB < 0 || l.OpenFiles < 0 || l.Processes < 0 {
+		return fmt.Errorf("limits for script '%s' must be >= 0", s.Path)
+	}
+	// RLIMIT_RSS has no effect on Linux, the memory is limited with the memory_max of the cgroup
+	if l.RSSMB > 0 {
+		return fmt.Errorf("rss_mb limit for script '%s' is not enforced, use the memory_max of its cgroup instead", s.Path)
+	}
+
+	if s.Nice < -20 || s.Nice > 19 {
+		return fmt.Errorf("nice for script '%s' must be between -20 and 19 (value passed: %d)", s.Path, s.Nice)
+	}
+	if !lib.StringIsInSlice(s.IONiceClass, validIONiceClasses) {
+		return fmt.Errorf("Invalid ionice_class '%s' for script '%s'", s.IONiceClass, s.Path)
+	}
+	if s.IONiceLevel < 0 || s.IONiceLevel > 7 {
+		return fmt.Errorf("ionice_level for script '%s' must be between 0 and 7 (value passed: %d)", s.Path, s.IONiceLevel)
+	}
+
+	return nil
+}
+
+func (s *Script) validateUser() error {
+
+	runsAsRoot := os.Geteuid() == 0
+	if s.User != "" {
+		if strings.Contains(s.User, ":") {
+			return fmt.Errorf("user '%s' of script '%s' must be a user name or id, the group is set with run_as_group", s.User, s.Path)
+		}
+		uid, _, err := lib.LookupOwner(s.User)
+		if err != nil {
+			return fmt.Errorf("invalid user '%s' for script '%s': %v", s.User, s.Path, err)
+		}
+		runsAsRoot = runsAsRoot && uid == 0
+	}
+	if s.RunAsGroup != "" {
+		if s.User == "" {
+			return fmt.Errorf("run_as_group of script '%s' requires a user", s.Path)
+		}
+		if _, _, err := lib.LookupOwner(":" + s.RunAsGroup); err != nil {
+			return fmt.Errorf("invalid run_as_group '%s' for script '%s': %v", s.RunAsGroup, s.Path, err)
+		}
+	}
+	for _, group := range s.SupplementaryGroups {
+		if _, _, err := lib.LookupOwner(":" + group); err != nil {
+			return fmt.Errorf("invalid supplementary group '%s' for script '%s': %v", group, s.Path, err)
+		}
+	}
+
+	if runsAsRoot {
+		// RLIMIT_NPROC counts the processes of the user and isn't enforced for root
+		if s.Limits.Processes > 0 {
+			return fmt.Errorf("processes limit for script '%s' is not enforced as it runs as root, use the pids_max of its cgroup instead", s.Path)
+		}
+		return checkNotWritableByUsers(s.Path)
+	}
+	return nil
+}
+
+// checkNotWritableByUsers returns an error when the script, or any directory up to the
+// root, can be modified by a user other than root, as it would allow anyone to run
+// commands as root through the executor
+func checkNotWritableByUsers(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for p := absPath; ; p = filepath.Dir(p) {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		// Files of a sticky directory can only be replaced by their owner
+		if ok && !(fi.IsDir() && fi.Mode()&os.ModeSticky != 0) {
+			mode := fi.Mode().Perm()
+			if st.Uid != 0 || mode&0002 != 0 || (mode&0020 != 0 && st.Gid != 0) {
+				return fmt.Errorf("script '%s' runs as root but %s is writable by other users", path, p)
+			}
+		}
+		if p == filepath.Dir(p) {
+			return nil
+		}
+	}
+}
+
+func (s Sandbox) validate(path string) error {
+	if len(s.WritablePaths) > 0 && !s.ReadOnlyRoot {
+		return fmt.Errorf("writable_paths of script '%s' requires read_only_root", path)
+	}
+	for _, p := range s.WritablePaths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("writable path '%s' of script '%s' must be absolute", p, path)
+		}
+	}
+	if s.Enabled() && os.Geteuid() != 0 {
+		return fmt.Errorf("the sandbox of script '%s' requires the executor to run as root", path)
+	}
+	return nil
+}
+
+// validateProbe checks that only the scripts executed for a target, which are the probe
+// scripts executed through the /probe endpoint rather than on schedule and the scripts
+// with targets, refer to the target in their args, and that probe scripts do refer to it
+// as they would otherwise check the same thing whatever the probed target
+func (s *Script) validateProbe() error {
+	refersToTarget := false
+	for _, arg := range s.Args {
+		if strings.Contains(arg, TargetPlaceholder) {
+			refersToTarget = true
+		}
+	}
+	if !s.Probe {
+		if refersToTarget && !s.HasTargets() {
+			return fmt.Errorf("args of script '%s' refer to %s, but the script has no targets and isn't a probe", s.Path, TargetPlaceholder)
+		}
+		return nil
+	}
+	if !refersToTarget {
+		return fmt.Errorf("args of probe script '%s' must refer to the probed target with %s", s.Path, TargetPlaceholder)
+	}
+	if len(s.DependsOn) > 0 {
+		return fmt.Errorf("probe script '%s' can't depend on other scripts", s.Path)
+	}
+	if s.Schedule != "" || s.CheckPeriod != "" {
+		return fmt.Errorf("probe script '%s' can't have a schedule or check_period", s.Path)
+	}
+	return nil
+}
+
+// validateThresholds checks the ranges of the thresholds, which only apply to the scripts
+// whose output is parsed into values, and that the metrics they are set for exist
+func (s *Script) validateThresholds() error {
+	threshold := Threshold{Warning: s.Warning, Critical: s.Critical}
+	if !threshold.IsSet() && len(s.Thresholds) == 0 {
+		return nil
+	}
+	if s.OutputType != "stdout" && s.OutputType != "multi_metric" {
+		return fmt.Errorf("thresholds of script '%s' only apply to the stdout and multi_metric output types", s.Path)
+	}
+	if len(s.Thresholds) > 0 {
+		if s.OutputType != "multi_metric" {
+			return fmt.Errorf("thresholds of script '%s' can only be set by metric for the multi_metric output type", s.Path)
+		}
+		re, err := regexp.Compile(s.MetricsRegex)
+		if err != nil {
+			return fmt.Errorf("invalid metrics_regex for script '%s': %v", s.Path, err)
+		}
+		captures := map[string]bool{}
+		for _, name := range re.SubexpNames() {
+			captures[name] = name != ""
+		}
+		for metric := range s.Thresholds {
+			if !captures[metric] {
+				return fmt.Errorf("thresholds of script '%s' are set for '%s', which isn't a capture group of its metrics_regex", s.Path, metric)
+			}
+		}
+	}
+
+	thresholds := []Threshold{threshold}
+	for _, t := range s.Thresholds {
+		thresholds = append(thresholds, t)
+	}
+	for _, t := range thresholds {
+		for _, value := range []string{t.Warning, t.Critical} {
+			if value == "" {
+				continue
+			}
+			if _, err := lib.ParseNagiosRange(value); err != nil {
+				return fmt.Errorf("invalid threshold for script '%s': %v", s.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Script) initAlert() error {
+	if s.Alert.For == "" {
+		s.Alert.For = "5m"
+	} else if !promDurationRegex.MatchString(s.Alert.For) {
+		return fmt.Errorf("invalid alert for duration string '%s' for script '%s', it must be a Prometheus duration such as 5m", s.Alert.For, s.Path)
+	}
+	if s.Alert.Severity == "" {
+		s.Alert.Severity = "warning"
+	}
+	if s.Alert.RunbookURL != "" {
+		if u, err := url.Parse(s.Alert.RunbookURL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("invalid alert runbook_url '%s' for script '%s'", s.Alert.RunbookURL, s.Path)
+		}
+	}
+	for alert := range s.Alert.Summaries {
+		if !lib.StringIsInSlice(alert, AlertNames) {
+			return fmt.Errorf("unknown alert '%s' in the alert summaries of script '%s', it must be one of %s", alert, s.Path, strings.Join(AlertNames, ", "))
+		}
+	}
+	return nil
+}
+
+// validateDependencies checks that the scripts named in depends_on exist, and that the
+// dependencies don't form a cycle
+func (c *Config) validateDependencies() error {
+	names := map[string]int{}
+	probes := map[string]bool{}
+	dependsOn := map[string][]string{}
+	for _, s := range c.Scripts {
+		names[s.Name]++
+		probes[s.Name] = s.Probe
+		dependsOn[s.Name] = s.DependsOn
+	}
+	for _, s := range c.Scripts {
+		for _, parent := range s.DependsOn {
+			if names[parent] == 0 {
+				return fmt.Errorf("script '%s' depends on unknown script '%s'", s.Path, parent)
+			}
+			if probes[parent] {
+				return fmt.Errorf("script '%s' depends on probe script '%s'", s.Path, parent)
+			}
+			if names[parent] > 1 || names[s.Name] > 1 {
+				return fmt.Errorf("script '%s' depends on '%s', but script names used in depends_on must be unique", s.Path, parent)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		switch state[name] {
+		case visiting:
+			for i := range chain {
+				if chain[i] == name {
+					chain = chain[i:]
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle between scripts: %s", strings.Join(append(chain, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, parent := range dependsOn[name] {
+			if err := visit(parent, append(chain, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, s := range c.Scripts {
+		if err := visit(s.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSchedules checks the cron schedules and check periods of the scripts, and the
+// time periods with their exclusions
+func (c *Config) validateSchedules() error {
+	for name, period := range c.TimePeriods {
+		if _, err := lib.ParseTimeRanges(period.Ranges); err != nil {
+			return fmt.Errorf("invalid time period '%s': %v", name, err)
+		}
+		for _, excluded := range period.Exclude {
+			if _, ok := c.TimePeriods[excluded]; !ok {
+				return fmt.Errorf("time period '%s' excludes unknown time period '%s'", name, excluded)
+			}
+		}
+	}
+	for name := range c.TimePeriods {
+		if err := c.checkExclusionCycle(name, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.Scripts {
+		if s.Schedule != "" {
+			schedule, err := lib.ParseCronSchedule(s.Schedule)
+			if err != nil {
+				return fmt.Errorf("invalid schedule for script '%s': %v", s.Path, err)
+			}
+			if schedule.Next(time.Now()).IsZero() {
+				return fmt.Errorf("schedule '%s' of script '%s' never matches", s.Schedule, s.Path)
+			}
+		}
+		if _, ok := c.TimePeriods[s.CheckPeriod]; s.CheckPeriod != "" && !ok {
+			return fmt.Errorf("check_period of script '%s' is an unknown time period '%s'", s.Path, s.CheckPeriod)
+		}
+	}
+	return nil
+}
+
+func (c *Config) checkExclusionCycle(name string, seen map[string]bool) error {
+	if seen[name] {
+		return fmt.Errorf("time period '%s' excludes itself", name)
+	}
+	seen[name] = true
+	for _, excluded := range c.TimePeriods[name].Exclude {
+		if err := c.checkExclusionCycle(excluded, seen); err != nil {
+			return err
+		}
+	}
+	delete(seen, name)
+	return nil
+}
+
+func (c *Cgroups) validate() error {
+
+	// The cgroups of the scripts are directly under the parent unless they're split by group
+	validSplitBy := []string{"", "group"}
+
+	if c.Parent != "" && !filepath.IsAbs(c.Parent) {
+		return fmt.Errorf("parent cgroup '%s' must be an absolute path", c.Parent)
+	}
+	if !lib.StringIsInSlice(c.SplitBy, validSplitBy) {
+		return fmt.Errorf("Invalid cgroups split_by '%s'", c.SplitBy)
+	}
+	for group, limits := range c.Groups {
+		if err := limits.validate("group " + group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l CgroupLimits) validate(owner string) error {
+	if l.MemoryMax != "" && !cgroupMemoryMaxRegex.MatchString(l.MemoryMax) {
+		return fmt.Errorf("invalid cgroup memory_max '%s' for '%s'", l.MemoryMax, owner)
+	}
+	if l.CPUMax != "" && !cgroupCPUMaxRegex.MatchString(l.CPUMax) {
+		return fmt.Errorf("invalid cgroup cpu_max '%s' for '%s' (format: \"$MAX $PERIOD\")", l.CPUMax, owner)
+	}
+	if l.PidsMax < 0 {
+		return fmt.Errorf("cgroup pids_max for '%s' must be >= 0 (value passed: %d)", owner, l.PidsMax)
+	}
+	return nil
+}
+
+func (o *Output) initAndValidate() error {
+
+	validProtocols := map[string][]string{
+		"textfile": {"file"},
+		"stdout":   {"stdout"},
+		"http":     {"http"},
+		"influxdb": {"http", "udp", "file"},
+		"graphite": {"tcp"},
+		"statsd":   {"udp"},
+		"otlp":     {"http"},
+	}
+
+	protocols, ok := validProtocols[o.Type]
+	if !ok {
+		return fmt.Errorf("Invalid output type: %s", o.Type)
+	}
+	if o.Name == "" {
+		o.Name = o.Type
+	}
+	if o.Protocol == "" {
+		o.Protocol = protocols[0]
+	}
+	if !lib.StringIsInSlice(o.Protocol, protocols) {
+		return fmt.Errorf("Invalid protocol '%s' for output '%s'", o.Protocol, o.Name)
+	}
+
+	if o.Type == "textfile" {
+		if err := o.validateTextfile(); err != nil {
+			return err
+		}
+	} else if o.Protocol == "file" && o.Path == "" {
+		return fmt.Errorf("must specify a path for output '%s'", o.Name)
+	}
+	if o.Protocol != "file" && o.Protocol != "stdout" && o.Address == "" {
+		return fmt.Errorf("must specify an address for output '%s'", o.Name)
+	}
+
+	if o.Timeout == "" {
+		o.Timeout = "5s"
+	} else if _, err := time.ParseDuration(o.Timeout); err != nil {
+		return fmt.Errorf("invalid timeout duration string '%s' for output '%s'", o.Timeout, o.Name)
+	}
+
+	if d, err := time.ParseDuration(o.ExportInterval); o.ExportInterval != "" && (err != nil || d <= 0) {
+		return fmt.Errorf("invalid export interval '%s' for output '%s'", o.ExportInterval, o.Name)
+	}
+
+	return nil
+}
+
+func (o *Output) validateTextfile() error {
+
+	validSplitBy := []string{"", "script", "group"}
+
+	if !lib.StringIsInSlice(o.SplitBy, validSplitBy) {
+		return fmt.Errorf("Invalid split_by '%s' for output '%s'", o.SplitBy, o.Name)
+	}
+	if o.SplitBy == "" && o.Path == "" {
+		return fmt.Errorf("must specify a path for output '%s'", o.Name)
+	}
+	if o.SplitBy != "" && o.Directory == "" {
+		return fmt.Errorf("must specify a directory for output '%s'", o.Name)
+	}
+
+	if o.FileMode == "" {
+		o.FileMode = "0644"
+	} else if _, err := strconv.ParseUint(o.FileMode, 8, 32); err != nil {
+		return fmt.Errorf("invalid file mode '%s' for output '%s'", o.FileMode, o.Name)
 	}
 
 	return nil
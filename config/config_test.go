@@ -0,0 +1,265 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr string
+	}{
+		{name: "no limits"},
+		{name: "enforced limits", limits: Limits{CPUSeconds: 5, AddressSpaceMB: 512, OpenFiles: 256, Processes: 64}},
+		{name: "negative limit", limits: Limits{OpenFiles: -1}, wantErr: "must be >= 0"},
+		{name: "rss", limits: Limits{RSSMB: 256}, wantErr: "rss_mb limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Script{Path: "/bin/check", Limits: tt.limits}
+			checkError(t, s.validateLimits(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateUserProcessesLimit(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the processes limit is only rejected for the scripts running as root")
+	}
+	path := filepath.Join(t.TempDir(), "check")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		limits  Limits
+		wantErr string
+	}{
+		{name: "root without processes limit"},
+		{name: "root with processes limit", limits: Limits{Processes: 64}, wantErr: "processes limit"},
+		{name: "explicit root with processes limit", user: "0", limits: Limits{Processes: 64}, wantErr: "processes limit"},
+		{name: "other user with processes limit", user: "65534", limits: Limits{Processes: 64}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Script{Path: path, User: tt.user, Limits: tt.limits}
+			checkError(t, s.validateUser(), tt.wantErr)
+		})
+	}
+}
+
+// checkError fails the test when err doesn't contain wantErr, or isn't nil when wantErr is empty
+func checkError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Fatalf("error = %v, want it to contain %q", err, wantErr)
+	}
+}
+
+func TestCgroupsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cgroups Cgroups
+		wantErr string
+	}{
+		{name: "disabled"},
+		{name: "per script", cgroups: Cgroups{Parent: "/sys/fs/cgroup/n2p"}},
+		{name: "per group", cgroups: Cgroups{Parent: "/sys/fs/cgroup/n2p", SplitBy: "group"}},
+		{name: "split by script", cgroups: Cgroups{Parent: "/sys/fs/cgroup/n2p", SplitBy: "script"}, wantErr: "Invalid cgroups split_by"},
+		{name: "relative parent", cgroups: Cgroups{Parent: "n2p"}, wantErr: "must be an absolute path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, tt.cgroups.validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateUserRunAsGroup(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		runAsGroup string
+		wantErr    string
+	}{
+		{name: "user", user: "65534"},
+		{name: "user and group", user: "65534", runAsGroup: "0"},
+		{name: "group in user", user: "65534:0", wantErr: "run_as_group"},
+		{name: "group without user", runAsGroup: "0", wantErr: "requires a user"},
+		{name: "unknown group", user: "65534", runAsGroup: "n2p-no-such-group", wantErr: "invalid run_as_group"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Script{Path: "/bin/check", User: tt.user, RunAsGroup: tt.runAsGroup}
+			checkError(t, s.validateUser(), tt.wantErr)
+		})
+	}
+}
+
+func TestCheckNotWritableByUsers(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the files must be owned by root")
+	}
+
+	tests := []struct {
+		name    string
+		dirMode os.FileMode
+		mode    os.FileMode
+		wantErr bool
+	}{
+		{name: "owned by root", dirMode: 0755, mode: 0755},
+		{name: "writable script", dirMode: 0755, mode: 0777, wantErr: true},
+		{name: "writable parent", dirMode: 0777, mode: 0755, wantErr: true},
+		{name: "sticky parent", dirMode: 0777 | os.ModeSticky, mode: 0755},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The script is two directories down, so that the writable one isn't its parent
+			dir := filepath.Join(t.TempDir(), "parent")
+			if err := os.MkdirAll(filepath.Join(dir, "plugins"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dir, tt.dirMode); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(dir, "plugins", "check")
+			if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkNotWritableByUsers(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkNotWritableByUsers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	script := func(name string, dependsOn ...string) Script {
+		return Script{Name: name, Path: "/usr/lib/nagios/plugins/" + name, DependsOn: dependsOn}
+	}
+	tests := []struct {
+		name    string
+		scripts []Script
+		wantErr string
+	}{
+		{name: "no dependencies", scripts: []Script{script("a"), script("b")}},
+		{name: "chain", scripts: []Script{script("a"), script("b", "a"), script("c", "b")}},
+		{name: "diamond", scripts: []Script{script("a"), script("b", "a"), script("c", "a"), script("d", "b", "c")}},
+		{name: "unknown parent", scripts: []Script{script("a", "x")}, wantErr: "unknown script 'x'"},
+		{name: "probe parent", scripts: []Script{{Name: "p", Path: "/p", Probe: true}, script("a", "p")}, wantErr: "depends on probe script"},
+		{name: "duplicate parent name", scripts: []Script{script("a"), script("a"), script("b", "a")}, wantErr: "must be unique"},
+		{name: "self dependency", scripts: []Script{script("a", "a")}, wantErr: "cycle between scripts: a -> a"},
+		{name: "cycle", scripts: []Script{script("a", "c"), script("b", "a"), script("c", "b")}, wantErr: "cycle between scripts: a -> c -> b -> a"},
+		{name: "cycle below a root", scripts: []Script{script("a"), script("b", "a", "c"), script("c", "b")}, wantErr: "cycle between scripts: b -> c -> b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Scripts: tt.scripts}
+			checkError(t, c.validateDependencies(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateProbe(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  Script
+		wantErr string
+	}{
+		{name: "scheduled", script: Script{Path: "/p", Args: []string{"-H", "localhost"}}},
+		{name: "scheduled with targets", script: Script{Path: "/p", Args: []string{"-H", "{{target}}"}, Targets: []string{"web1"}}},
+		{name: "scheduled referring to the target", script: Script{Path: "/p", Args: []string{"-H", "{{target}}"}}, wantErr: "has no targets and isn't a probe"},
+		{name: "probe", script: Script{Path: "/p", Args: []string{"-H", "{{target}}"}, Probe: true}},
+		{name: "probe with the target within an arg", script: Script{Path: "/p", Args: []string{"--url=https://{{target}}/health"}, Probe: true}},
+		{name: "probe without args", script: Script{Path: "/p", Probe: true}, wantErr: "must refer to the probed target"},
+		{name: "probe not referring to the target", script: Script{Path: "/p", Args: []string{"-H", "localhost"}, Probe: true}, wantErr: "must refer to the probed target"},
+		{name: "probe with dependencies", script: Script{Path: "/p", Args: []string{"{{target}}"}, Probe: true, DependsOn: []string{"a"}}, wantErr: "can't depend on other scripts"},
+		{name: "probe with a schedule", script: Script{Path: "/p", Args: []string{"{{target}}"}, Probe: true, Schedule: "* * * * *"}, wantErr: "can't have a schedule"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkError(t, tt.script.validateProbe(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadResolvesTargetFiles(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "check_http")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+	}{
+		{name: "relative", pattern: "targets/*.json", want: filepath.Join(dir, "targets/*.json")},
+		{name: "relative to a parent", pattern: "../targets/*.yml", want: filepath.Join(filepath.Dir(dir), "targets/*.yml")},
+		{name: "absolute", pattern: "/etc/n2p/targets/*.json", want: "/etc/n2p/targets/*.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config.yml")
+			content := "scripts:\n  - name: check_http\n    path: " + script + "\n    output_type: exit_code\n    args: [\"{{target}}\"]\n    target_files: [\"" + tt.pattern + "\"]\n"
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cnf, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got := cnf.Scripts[0].TargetFiles[0]; got != tt.want {
+				t.Errorf("target file pattern = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitAlert(t *testing.T) {
+	tests := []struct {
+		name    string
+		alert   Alert
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "summaries", alert: Alert{Summary: "failing", Summaries: map[string]string{"ScriptStateNotOK": "not OK", "ScriptResultStale": "stale"}}},
+		{name: "unknown alert in summaries", alert: Alert{Summaries: map[string]string{"ScriptDown": "down"}}, wantErr: "unknown alert 'ScriptDown'"},
+		{name: "invalid for", alert: Alert{For: "5 minutes"}, wantErr: "invalid alert for duration"},
+		{name: "relative runbook_url", alert: Alert{RunbookURL: "runbooks/dns"}, wantErr: "invalid alert runbook_url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Script{Path: "/bin/check", Alert: tt.alert}
+			checkError(t, s.initAlert(), tt.wantErr)
+		})
+	}
+}
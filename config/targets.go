@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/go-yaml/yaml"
+)
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// TargetGroup is a group of targets sharing the same labels, in the format of the file
+// based service discovery of Prometheus (file_sd)
+type TargetGroup struct {
+	Targets []string          `yaml:"targets" json:"targets"`
+	Labels  map[string]string `yaml:"labels" json:"labels"`
+}
+
+// LoadTargetFile loads the target groups from the file, parsed as JSON or YAML depending
+// on its extension
+func LoadTargetFile(path string) ([]TargetGroup, error) {
+	if err := validateTargetFileExt(path); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []TargetGroup
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(content, &groups)
+	} else {
+		err = yaml.Unmarshal(content, &groups)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse target file '%s': %v", path, err)
+	}
+
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			if err := ValidateTarget(target); err != nil {
+				return nil, fmt.Errorf("invalid target in target file '%s': %v", path, err)
+			}
+		}
+		for name := range group.Labels {
+			if !labelNameRegex.MatchString(name) || name == "script" || name == "target" {
+				return nil, fmt.Errorf("invalid label name '%s' in target file '%s'", name, path)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// ValidateTarget checks that the target can be passed to a script.  The target is quoted
+// when passed to the script, but it mustn't look like an option of the script.
+func ValidateTarget(target string) error {
+	if target == "" {
+		return errors.New("target is empty")
+	}
+	if strings.HasPrefix(target, "-") {
+		return fmt.Errorf("invalid target '%s', it can't start with '-'", target)
+	}
+	for _, c := range target {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return fmt.Errorf("invalid target '%s', it can't contain whitespace or control characters", target)
+		}
+	}
+	return nil
+}
+
+func validateTargetFileExt(path string) error {
+	switch filepath.Ext(path) {
+	case ".json", ".yml", ".yaml":
+		return nil
+	}
+	return fmt.Errorf("target file '%s' must have a .json, .yml or .yaml extension", path)
+}
+
+// validateTargets checks the targets the script is executed against, which are only
+// given by the /probe requests for probe scripts
+func (s *Script) validateTargets() error {
+	if s.Probe && (len(s.Targets) > 0 || len(s.TargetFiles) > 0) {
+		return fmt.Errorf("probe script '%s' can't have targets or target_files", s.Path)
+	}
+	for _, target := range s.Targets {
+		if err := ValidateTarget(target); err != nil {
+			return fmt.Errorf("invalid target for script '%s': %v", s.Path, err)
+		}
+	}
+	for _, pattern := range s.TargetFiles {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid target file pattern '%s' for script '%s': %v", pattern, s.Path, err)
+		}
+		if err := validateTargetFileExt(pattern); err != nil {
+			return fmt.Errorf("invalid target_files for script '%s': %v", s.Path, err)
+		}
+	}
+	return nil
+}
+
+// HasTargets returns true when the script is executed once per target
+func (s Script) HasTargets() bool {
+	return len(s.Targets) > 0 || len(s.TargetFiles) > 0
+}
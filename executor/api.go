@@ -0,0 +1,253 @@
+package executor
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// apiScript is a script in the responses of the management API, along with the result
+// of its last execution, or of its last execution for each target when it has targets
+type apiScript struct {
+	Config     config.Script         `json:"config"`
+	LastResult *apiResult            `json:"last_result"`
+	Targets    map[string]*apiResult `json:"targets,omitempty"`
+}
+
+// apiResult is the result of an execution of a script in the responses of the management API
+type apiResult struct {
+	Timestamp  time.Time   `json:"timestamp"`
+	Success    bool        `json:"success"`
+	Skipped    bool        `json:"skipped"`
+	Value      *float64    `json:"value"`
+	Metrics    []apiMetric `json:"metrics"`
+	DurationMs int64       `json:"duration_ms"`
+	Attempts   int         `json:"attempts"`
+	Error      string      `json:"error,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	Stdout     string      `json:"stdout"`
+	Stderr     string      `json:"stderr"`
+}
+
+// apiMetric is a metric parsed from the output of a script
+type apiMetric struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// apiError is the response of the management API when a request fails
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// newAPIResult returns the result of the execution for the responses of the management API.
+// The value is only set when the execution resulted in a single metric.
+func newAPIResult(run lastRun) *apiResult {
+	res := run.Result
+	result := &apiResult{
+		Timestamp:  run.Timestamp,
+		Success:    res.Error == nil && !res.Skipped,
+		Skipped:    res.Skipped,
+		Metrics:    make([]apiMetric, 0, len(res.Metrics)),
+		DurationMs: res.TotalExecTime,
+		Attempts:   res.Attempts,
+		Stdout:     outputSnippet(res.Stdout),
+		Stderr:     outputSnippet(res.Stderr),
+	}
+	if res.Error != nil {
+		result.Error = res.Error.Error()
+		result.Reason = failureReason(res.Error)
+	}
+	for _, metric := range res.Metrics {
+		result.Metrics = append(result.Metrics, apiMetric{
+			Name:   metric.FullName(),
+			Labels: metric.Labels,
+			Value:  metric.Value,
+		})
+	}
+	if len(res.Metrics) == 1 {
+		result.Value = &res.Metrics[0].Value
+	}
+	return result
+}
+
+// serveAPI starts serving the management API on the address:
+//   - GET /api/v1/scripts lists the scripts with the result of their last execution
+//   - POST /api/v1/scripts/{name}/run executes the script, against the target parameter when
+//     it has targets, right away and returns its result
+//   - POST /api/v1/reload reloads the config
+//   - GET /probe executes a probe script against a target, see handleProbe
+func (d *daemon) serveAPI(address string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/scripts", d.handleScripts)
+	mux.HandleFunc("/api/v1/scripts/", d.handleRunScript)
+	mux.HandleFunc("/api/v1/reload", d.handleReload)
+	mux.HandleFunc("/probe", d.handleProbe)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Stopped serving the management API: %v", err)
+		}
+	}()
+	log.Infof("Serving the management API on %s", listener.Addr())
+	return server, nil
+}
+
+func (d *daemon) handleScripts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	scripts := make([]apiScript, 0, len(d.cnf.Scripts))
+	for _, script := range d.cnf.Scripts {
+		s := apiScript{Config: script}
+		if script.HasTargets() {
+			s.Targets = map[string]*apiResult{}
+			for target, run := range d.results.targetRuns(script.Path) {
+				s.Targets[target] = newAPIResult(run)
+			}
+		} else if run, ok := d.results.lastRun(script.Path, ""); ok {
+			s.LastResult = newAPIResult(run)
+		}
+		scripts = append(scripts, s)
+	}
+	writeJSON(w, http.StatusOK, scripts)
+}
+
+// handleRunScript executes the script right away, outside of its schedule and regardless
+// of its dependencies, and returns its result.  A script with targets is executed against
+// the one given by the target parameter.  The script waits for a slot of its concurrency
+// group, shared with the scheduled executions.  The request is rejected while the script
+// is already running, and its scheduled execution waits for the requested one to complete.
+// The result is recorded as the last one of the script, and the metrics of a successful
+// execution are used by its failure policy, but its series are only written after its
+// next scheduled execution.
+func (d *daemon) handleRunScript(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/scripts/")
+	if !strings.HasSuffix(name, "/run") {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "not found"})
+		return
+	}
+	name = strings.TrimSuffix(name, "/run")
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	d.mu.RLock()
+	script, found := config.Script{}, false
+	for _, s := range d.cnf.Scripts {
+		if s.Name == name {
+			script, found = s, true
+			break
+		}
+	}
+	d.mu.RUnlock()
+	if !found {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "no script named '" + name + "'"})
+		return
+	}
+	if script.Probe {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "script '" + name + "' is a probe, it's executed through /probe"})
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if script.HasTargets() {
+		if target == "" {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "script '" + name + "' has targets, the target parameter is required"})
+			return
+		}
+		found = false
+		for _, execution := range expandTargets([]config.Script{script}) {
+			if execution.Target == target {
+				script, found = execution, true
+				break
+			}
+		}
+		if !found {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "script '" + name + "' has no target '" + target + "'"})
+			return
+		}
+	} else if target != "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "script '" + name + "' has no targets"})
+		return
+	}
+
+	// The slot of the group is taken before the script is marked as running, in the same
+	// order as the workers, so that they never wait on each other
+	if !d.groups.acquire(r.Context(), script.ConcurrencyGroup) {
+		return
+	}
+	defer d.groups.release(script.ConcurrencyGroup)
+	key := executionKey(script.Path, script.Target)
+	if !d.results.startRun(key, false) {
+		writeJSON(w, http.StatusConflict, apiError{Error: "script '" + name + "'" + targetSuffix(script.Target) + " is already running"})
+		return
+	}
+	log.Infof("Running script %s%s as requested through the management API", script.Path, targetSuffix(script.Target))
+	res := RunScript(script)
+	d.results.finishRun(key)
+	if res.Error != nil {
+		log.Errorf("Encountered error executing script %s%s (Error: %v)", script.Name, targetSuffix(script.Target), res.Error)
+	} else {
+		d.results.update(script, res.Metrics)
+	}
+	d.results.recordRun(res)
+	run, _ := d.results.lastRun(script.Path, script.Target)
+
+	d.mu.RLock()
+	result := newAPIResult(run)
+	d.mu.RUnlock()
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (d *daemon) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	// The config is reloaded by the main loop, once the scripts being executed are done
+	reply := make(chan error, 1)
+	select {
+	case d.reloads <- reply:
+	case <-r.Context().Done():
+		return
+	}
+	if err := <-reply; err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{Status: "reloaded"})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Could not write management API response: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package executor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestHandleRunScript(t *testing.T) {
+	scripts := []config.Script{
+		{Name: "check_ok", Path: "true", OutputType: "exit_code", Timeout: "5s"},
+		{Name: "check_failing", Path: "exit", Args: []string{"3"}, OutputType: "stdout", Timeout: "5s"},
+		{Name: "check_probe", Path: "true", OutputType: "exit_code", Timeout: "5s", Probe: true},
+		{Name: "check_db", Path: "true", OutputType: "exit_code", Timeout: "5s", ConcurrencyGroup: "db"},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		running    string
+		busyGroup  bool
+		wantStatus int
+		wantStored bool
+	}{
+		{name: "success", method: http.MethodPost, path: "/api/v1/scripts/check_ok/run", wantStatus: http.StatusOK, wantStored: true},
+		{name: "failure", method: http.MethodPost, path: "/api/v1/scripts/check_failing/run", wantStatus: http.StatusOK},
+		{name: "already running", method: http.MethodPost, path: "/api/v1/scripts/check_ok/run", running: "true", wantStatus: http.StatusConflict},
+		{name: "other script running", method: http.MethodPost, path: "/api/v1/scripts/check_ok/run", running: "exit", wantStatus: http.StatusOK, wantStored: true},
+		{name: "unknown script", method: http.MethodPost, path: "/api/v1/scripts/check_unknown/run", wantStatus: http.StatusNotFound},
+		{name: "probe", method: http.MethodPost, path: "/api/v1/scripts/check_probe/run", wantStatus: http.StatusBadRequest},
+		{name: "unexpected target", method: http.MethodPost, path: "/api/v1/scripts/check_ok/run?target=web1", wantStatus: http.StatusBadRequest},
+		{name: "concurrency group busy", method: http.MethodPost, path: "/api/v1/scripts/check_db/run", busyGroup: true, wantStatus: http.StatusOK, wantStored: true},
+		{name: "GET", method: http.MethodGet, path: "/api/v1/scripts/check_ok/run", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &daemon{cnf: &config.Config{Scripts: scripts}, results: newResultStore(), groups: newGroupLimiter(map[string]int{"db": 1})}
+			if tt.running != "" {
+				d.results.startRun(executionKey(tt.running, ""), false)
+			}
+			// The slot of the group is released once the execution is shown to wait for it
+			released := make(chan struct{})
+			if tt.busyGroup {
+				d.groups.acquire(context.Background(), "db")
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					close(released)
+					d.groups.release("db")
+				}()
+			} else {
+				close(released)
+			}
+
+			w := httptest.NewRecorder()
+			d.handleRunScript(w, httptest.NewRequest(tt.method, tt.path, nil))
+			select {
+			case <-released:
+			default:
+				t.Fatal("the script was executed while its concurrency group had no slot left")
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if _, stored := d.results.Results["true"]; stored != tt.wantStored {
+				t.Errorf("stored result = %v, want %v", stored, tt.wantStored)
+			}
+			wantRunning := 0
+			if tt.running != "" {
+				wantRunning = 1
+			}
+			if len(d.results.running) != wantRunning {
+				t.Errorf("running executions = %v, want %d", d.results.running, wantRunning)
+			}
+			if d.groups.running["db"] != 0 {
+				t.Errorf("%d slots of the db group in use after the execution, want 0", d.groups.running["db"])
+			}
+		})
+	}
+}
+
+func TestResultStoreStartRun(t *testing.T) {
+	r := newResultStore()
+	if !r.startRun("a", false) {
+		t.Fatal("startRun() = false for an idle execution")
+	}
+	if r.startRun("a", false) {
+		t.Fatal("startRun() = true for a running execution")
+	}
+	if !r.startRun("b", false) {
+		t.Fatal("startRun() = false for another execution")
+	}
+
+	started := make(chan struct{})
+	go func() {
+		r.startRun("a", true)
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("startRun() didn't wait for the running execution")
+	case <-time.After(50 * time.Millisecond):
+	}
+	r.finishRun("a")
+	<-started
+	r.finishRun("a")
+	r.finishRun("b")
+	if !r.startRun("a", false) {
+		t.Fatal("startRun() = false once the execution is finished")
+	}
+}
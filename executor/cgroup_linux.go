@@ -0,0 +1,249 @@
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// cgroupControllers are the controllers enabled for the sub-tree of the scripts
+var cgroupControllers = []string{"cpu", "memory", "pids"}
+
+// cgroupSettings is replaced when the config is reloaded, while scripts are executed
+// through the management API, so it's guarded by cgroupSettingsMu
+var (
+	cgroupSettings   config.Cgroups
+	cgroupSettingsMu sync.RWMutex
+	cgroupRunID      uint64
+)
+
+// scriptCgroup is the cgroup a single execution of a script is placed in
+type scriptCgroup struct {
+	path string
+	dir  *os.File
+}
+
+// SetupCgroups creates the cgroup v2 sub-tree under the configured parent, with the
+// limits of each group when the scripts are split by group, and uses it for the next
+// executions once it's set up. Nothing is created when no parent is configured.
+func SetupCgroups(cgroups config.Cgroups) error {
+	if cgroups.Parent == "" {
+		setCgroupSettings(cgroups)
+		return nil
+	}
+
+	if err := os.MkdirAll(cgroups.Parent, 0755); err != nil {
+		return err
+	}
+	enableControllers(cgroups.Parent)
+
+	for group, limits := range cgroups.Groups {
+		path := filepath.Join(cgroups.Parent, sanitizeCgroupName(group))
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		enableControllers(path)
+		if err := writeCgroupLimits(path, limits); err != nil {
+			return fmt.Errorf("could not set limits of cgroup %s: %v", path, err)
+		}
+	}
+	setCgroupSettings(cgroups)
+	return nil
+}
+
+func setCgroupSettings(cgroups config.Cgroups) {
+	cgroupSettingsMu.Lock()
+	cgroupSettings = cgroups
+	cgroupSettingsMu.Unlock()
+}
+
+// newScriptCgroup creates the cgroup of a single execution of the script, under the
+// cgroup of the script which holds its limits. nil is returned when cgroups aren't used.
+func newScriptCgroup(script config.Script) (*scriptCgroup, error) {
+	cgroupSettingsMu.RLock()
+	settings := cgroupSettings
+	cgroupSettingsMu.RUnlock()
+	if settings.Parent == "" {
+		return nil, nil
+	}
+
+	name := script.Name
+	if name == "" {
+		name = lib.GetScriptName(script.Path)
+	}
+	scriptPath := settings.Parent
+	if settings.SplitBy == "group" {
+		group := script.Group
+		if group == "" {
+			group = "default"
+		}
+		scriptPath = filepath.Join(scriptPath, sanitizeCgroupName(group))
+	}
+	scriptPath = filepath.Join(scriptPath, sanitizeCgroupName(name))
+
+	if err := os.MkdirAll(scriptPath, 0755); err != nil {
+		return nil, err
+	}
+	enableControllers(filepath.Dir(scriptPath))
+	enableControllers(scriptPath)
+	if err := writeCgroupLimits(scriptPath, script.Cgroup); err != nil {
+		return nil, fmt.Errorf("could not set limits of cgroup %s: %v", scriptPath, err)
+	}
+
+	path := filepath.Join(scriptPath, fmt.Sprintf("run-%d", atomic.AddUint64(&cgroupRunID, 1)))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return &scriptCgroup{path: path, dir: dir}, nil
+}
+
+func sanitizeCgroupName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '.' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// enableControllers enables the controllers in the sub-tree of the cgroup. Controllers
+// which aren't available are ignored, the limits relying on them will fail to be set.
+func enableControllers(path string) {
+	for _, controller := range cgroupControllers {
+		if err := ioutil.WriteFile(filepath.Join(path, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			log.Debugf("Could not enable %s controller in cgroup %s: %v", controller, path, err)
+		}
+	}
+}
+
+// writeCgroupLimits writes the limits to the cgroup, resetting the ones not specified
+func writeCgroupLimits(path string, limits config.CgroupLimits) error {
+	values := []struct {
+		file  string
+		value string
+	}{
+		{"memory.max", limits.MemoryMax},
+		{"cpu.max", limits.CPUMax},
+		{"pids.max", ""},
+	}
+	if limits.PidsMax > 0 {
+		values[2].value = strconv.Itoa(limits.PidsMax)
+	}
+
+	for _, v := range values {
+		if v.value == "" {
+			// Failing to reset a limit only means the controller isn't available
+			ioutil.WriteFile(filepath.Join(path, v.file), []byte("max"), 0644)
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, v.file), []byte(v.value), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attach sets the command to be started directly in the cgroup
+func (c *scriptCgroup) attach(cmd *exec.Cmd) {
+	if c == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+// kill terminates every process in the cgroup, including the ones which left the
+// process group of the script
+func (c *scriptCgroup) kill() {
+	if c == nil {
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644); err == nil {
+		return
+	}
+	// cgroup.kill requires Linux 5.14, fall back to killing each process
+	content, err := ioutil.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+	if err != nil {
+		log.Warnf("Could not list processes of cgroup %s: %v", c.path, err)
+		return
+	}
+	for _, line := range strings.Fields(string(content)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// release kills the processes left in the cgroup, reads its resource usage and removes it
+func (c *scriptCgroup) release() *CgroupStats {
+	if c == nil {
+		return nil
+	}
+	defer c.dir.Close()
+
+	// Wait for the processes to be gone, so their usage is accounted and the cgroup
+	// can be removed
+	deadline := time.Now().Add(time.Second)
+	for c.populated() && time.Now().Before(deadline) {
+		c.kill()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := &CgroupStats{}
+	if content, err := ioutil.ReadFile(filepath.Join(c.path, "memory.peak")); err == nil {
+		stats.MemoryPeakBytes, _ = strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+	}
+	if f, err := os.Open(filepath.Join(c.path, "cpu.stat")); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			usec, _ := strconv.ParseFloat(fields[1], 64)
+			switch fields[0] {
+			case "user_usec":
+				stats.CPUUserSeconds = usec / 1e6
+			case "system_usec":
+				stats.CPUSystemSeconds = usec / 1e6
+			case "throttled_usec":
+				stats.CPUThrottledSeconds = usec / 1e6
+			}
+		}
+		f.Close()
+	}
+
+	if err := os.Remove(c.path); err != nil {
+		log.Warnf("Could not remove cgroup %s: %v", c.path, err)
+	}
+	return stats
+}
+
+func (c *scriptCgroup) populated() bool {
+	content, err := ioutil.ReadFile(filepath.Join(c.path, "cgroup.events"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(content), "populated 1")
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// scriptCgroup is only supported on Linux
+type scriptCgroup struct{}
+
+// SetupCgroups returns an error when a parent cgroup is configured, as cgroups are only
+// supported on Linux
+func SetupCgroups(cgroups config.Cgroups) error {
+	if cgroups.Parent != "" {
+		return errors.New("cgroups are only supported on Linux")
+	}
+	return nil
+}
+
+func newScriptCgroup(script config.Script) (*scriptCgroup, error) {
+	return nil, nil
+}
+
+func (c *scriptCgroup) attach(cmd *exec.Cmd) {}
+
+func (c *scriptCgroup) kill() {}
+
+func (c *scriptCgroup) release() *CgroupStats {
+	return nil
+}
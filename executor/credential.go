@@ -0,0 +1,66 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+// applyCredential sets the command to run as the user (and run_as_group) of the script,
+// with the HOME, USER and LOGNAME environment variables matching that user. The
+// supplementary groups default to the groups the user is a member of.
+func applyCredential(cmd *exec.Cmd, script config.Script) error {
+	if script.User == "" {
+		return nil
+	}
+
+	u, err := user.Lookup(script.User)
+	if err != nil {
+		if u, err = user.LookupId(script.User); err != nil {
+			return fmt.Errorf("could not find user %s: %v", script.User, err)
+		}
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	if script.RunAsGroup != "" {
+		if _, gid, err = lib.LookupOwner(":" + script.RunAsGroup); err != nil {
+			return fmt.Errorf("could not find group %s: %v", script.RunAsGroup, err)
+		}
+	}
+
+	groupIds := script.SupplementaryGroups
+	if len(groupIds) == 0 {
+		if groupIds, err = u.GroupIds(); err != nil {
+			groupIds = []string{}
+		}
+	}
+	groups := make([]uint32, 0, len(groupIds))
+	for _, group := range groupIds {
+		_, id, err := lib.LookupOwner(":" + group)
+		if err != nil {
+			return fmt.Errorf("could not find group %s: %v", group, err)
+		}
+		groups = append(groups, uint32(id))
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}
+	cmd.Env = append(os.Environ(),
+		"HOME="+u.HomeDir,
+		"USER="+u.Username,
+		"LOGNAME="+u.Username,
+	)
+	return nil
+}
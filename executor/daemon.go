@@ -0,0 +1,289 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	"github.com/hartfordfive/n2p-script-executor/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// daemon holds the state of the executor in daemon mode, which is carried over when the
+// config is reloaded
+type daemon struct {
+	cfg ExecutorConfig
+	// mu guards cnf, which is read by the management API while it's reloaded
+	mu      sync.RWMutex
+	cnf     *config.Config
+	outputs *sink.Fanout
+	results *resultStore
+	// groups limits the scripts executed at the same time by concurrency group, whether
+	// they are scheduled, requested through the management API or probes
+	groups     *groupLimiter
+	sched      *scheduler
+	configHash [sha256.Size]byte
+	// reloads receives the reloads requested through the management API
+	reloads chan chan error
+	// probeSlots holds a value for each probe in progress, up to max_concurrent_probes.
+	// It's guarded by mu as it's replaced when the limit is changed.
+	probeSlots chan struct{}
+
+	lastReloadSuccessful  bool
+	lastReloadSuccessTime time.Time
+}
+
+// runDaemon executes the scripts at every interval until the executor is interrupted.
+// The config is reloaded on SIGHUP and when the config file changes, and the management
+// API is served when a listen address is specified.
+func runDaemon(cfg ExecutorConfig, cnf *config.Config, outputs *sink.Fanout) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	configChanges := watchConfig(cfg.ConfigFilePath)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	d := &daemon{
+		cfg:                   cfg,
+		cnf:                   cnf,
+		outputs:               outputs,
+		results:               newResultStore(),
+		groups:                newGroupLimiter(cnf.ConcurrencyGroups),
+		sched:                 newScheduler(cnf, true),
+		reloads:               make(chan chan error),
+		probeSlots:            make(chan struct{}, cnf.MaxProbes),
+		lastReloadSuccessful:  true,
+		lastReloadSuccessTime: time.Now(),
+	}
+	d.results.countFailures()
+	d.configHash, _ = configHash(cfg.ConfigFilePath)
+
+	var api *http.Server
+	if cfg.ListenAddress != "" {
+		var err error
+		if api, err = d.serveAPI(cfg.ListenAddress); err != nil {
+			log.Errorf("Could not serve the management API: %v", err)
+			outputs.Close()
+			os.Exit(1)
+		}
+	}
+
+	log.Infof("Running in daemon mode, executing scripts every %v", cfg.Interval)
+	run := true
+	for {
+		if run {
+			d.run()
+		}
+
+		run = true
+		select {
+		case <-ticker.C:
+		case <-hupChan:
+			log.Info("Received SIGHUP, reloading config")
+			d.reload()
+			run = false
+		case <-configChanges:
+			if hash, err := configHash(cfg.ConfigFilePath); err == nil && hash != d.configHash {
+				log.Info("Config file changed, reloading config")
+				d.reload()
+			}
+			run = false
+		case reply := <-d.reloads:
+			log.Info("Reload requested through the management API, reloading config")
+			reply <- d.reload()
+			run = false
+		case sig := <-sigChan:
+			log.Infof("Received signal %v, shutting down", sig)
+			if api != nil {
+				api.Close()
+			}
+			d.outputs.Close()
+			return
+		}
+	}
+}
+
+// run executes the scripts which are due and writes the resulting series to the outputs
+func (d *daemon) run() {
+	now := time.Now()
+	due := d.sched.due(d.cnf.Scripts, now)
+	// The splay of each script is its phase offset within the interval
+	series := d.sched.complete(d.cnf.Scripts, due, execute(d.cnf, due, d.results, d.groups, d.cfg.Interval), now)
+	series = append(series, d.reloadSeries()...)
+	log.Infof("Writing resulting series to %s", d.outputs.Name())
+	d.outputs.Write(series)
+}
+
+// reload loads the config again and applies it only when it's valid and its outputs and
+// cgroups could be set up, otherwise the current config is kept and the error returned
+func (d *daemon) reload() error {
+	if err := d.applyConfig(); err != nil {
+		log.Errorf("Could not reload config, keeping the current one: %v", err)
+		d.lastReloadSuccessful = false
+		return err
+	}
+	d.lastReloadSuccessful = true
+	d.lastReloadSuccessTime = time.Now()
+	log.Info("Config reloaded")
+	return nil
+}
+
+// applyConfig loads the config and replaces the current one with it.  The state of the
+// scripts which are unchanged is kept, while the scripts which were added or changed are
+// scheduled anew.
+func (d *daemon) applyConfig() error {
+	// The hash is recorded even when the config is rejected, so that the same content
+	// isn't reloaded again at each change notification
+	d.configHash, _ = configHash(d.cfg.ConfigFilePath)
+
+	cnf, err := config.Load(d.cfg.ConfigFilePath)
+	if err != nil {
+		return err
+	}
+
+	outputs, err := d.reloadOutputs(cnf)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(cnf.Cgroups, d.cnf.Cgroups) {
+		// The current cgroups are kept in use when the new ones can't be set up
+		if err := SetupCgroups(cnf.Cgroups); err != nil {
+			closeUnused(outputs, d.outputs)
+			return fmt.Errorf("could not set up cgroups: %v", err)
+		}
+	}
+	closeUnused(d.outputs, outputs)
+	d.outputs = outputs
+
+	previous := make(map[string]config.Script, len(d.cnf.Scripts))
+	for _, script := range d.cnf.Scripts {
+		previous[script.Path] = script
+	}
+	unchanged := map[string]bool{}
+	for _, script := range cnf.Scripts {
+		if old, ok := previous[script.Path]; ok && reflect.DeepEqual(old, script) {
+			unchanged[script.Path] = true
+		} else {
+			log.Infof("Script %s was added or changed", script.Path)
+		}
+		delete(previous, script.Path)
+	}
+	for path := range previous {
+		log.Infof("Script %s was removed", path)
+	}
+	d.groups.setLimits(cnf.ConcurrencyGroups)
+	d.results.keep(unchanged)
+	d.sched = d.sched.reload(cnf, unchanged)
+
+	d.mu.Lock()
+	d.cnf = cnf
+	// The probes in progress release their slot in the channel they acquired it from
+	if cap(d.probeSlots) != cnf.MaxProbes {
+		d.probeSlots = make(chan struct{}, cnf.MaxProbes)
+	}
+	if cnf.SeriesPrefix != "" {
+		lib.SetSeriesPrefix(cnf.SeriesPrefix)
+	} else {
+		lib.SetSeriesPrefix(lib.DefaultSeriesPrefix)
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// reloadOutputs returns the fan-out to the outputs of the reloaded config.  The sinks of
+// the unchanged outputs are kept, along with their state such as the OTLP histograms,
+// unless they are textfile outputs whose files change with the scripts.  The outcome of
+// the previous writes is carried over.
+func (d *daemon) reloadOutputs(cnf *config.Config) (*sink.Fanout, error) {
+	previous := map[string]config.Output{}
+	for _, out := range outputConfigs(d.cfg, d.cnf) {
+		previous[out.Name] = out
+	}
+	current := map[string]sink.Sink{}
+	for _, s := range d.outputs.Sinks() {
+		current[s.Name()] = s
+	}
+
+	sinks := []sink.Sink{}
+	created := []sink.Sink{}
+	for _, out := range outputConfigs(d.cfg, cnf) {
+		if s, ok := current[out.Name]; ok && reflect.DeepEqual(previous[out.Name], out) &&
+			reflect.DeepEqual(sink.TextfileFiles(out, d.cnf.Scripts), sink.TextfileFiles(out, cnf.Scripts)) {
+			sinks = append(sinks, s)
+			continue
+		}
+		s, err := sink.New(out, cnf.Scripts, d.cfg.Interval > 0)
+		if err != nil {
+			for _, c := range created {
+				c.Close()
+			}
+			return nil, err
+		}
+		log.Infof("Output %s was added or changed", out.Name)
+		created = append(created, s)
+		sinks = append(sinks, s)
+	}
+
+	outputs := sink.NewFanout(sinks)
+	outputs.RestoreStats(d.outputs.Stats())
+	return outputs, nil
+}
+
+// closeUnused closes the sinks of the fan-out which aren't written to by the one in use
+func closeUnused(outputs *sink.Fanout, inUse *sink.Fanout) {
+	used := map[sink.Sink]bool{}
+	for _, s := range inUse.Sinks() {
+		used[s] = true
+	}
+	for _, s := range outputs.Sinks() {
+		if used[s] {
+			continue
+		}
+		if err := s.Close(); err != nil {
+			log.Errorf("Could not close output %s: %v", s.Name(), err)
+		}
+	}
+}
+
+// reloadSeries returns the series of the outcome of the last reload of the config
+func (d *daemon) reloadSeries() []lib.Metric {
+	successful := 0.0
+	if d.lastReloadSuccessful {
+		successful = 1.0
+	}
+	return []lib.Metric{
+		lib.Metric{
+			Name:  "config_last_reload_successful",
+			Value: successful,
+			Type:  "gauge",
+			Help:  "indicates whether the last reload of the config was successful",
+		},
+		lib.Metric{
+			Name:  "config_last_reload_success_timestamp_seconds",
+			Value: float64(d.lastReloadSuccessTime.Unix()),
+			Type:  "gauge",
+			Help:  "indicates the time of the last successful reload of the config",
+		},
+	}
+}
+
+// configHash returns the hash of the content of the config file
+func configHash(path string) ([sha256.Size]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(content), nil
+}
@@ -0,0 +1,129 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/sink"
+)
+
+func TestReloadRecordsConfigHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid YAML", content: "scripts: [\n"},
+		{name: "invalid config", content: "scripts:\n  - path: /plugins/check\n    output_type: unknown\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			current := &config.Config{}
+			d := &daemon{cfg: ExecutorConfig{ConfigFilePath: path}, cnf: current, lastReloadSuccessful: true}
+
+			if err := d.reload(); err == nil {
+				t.Fatal("reload() succeeded, want an error")
+			}
+			want, _ := configHash(path)
+			if d.configHash != want {
+				t.Error("the hash of the rejected config wasn't recorded")
+			}
+			if d.cnf != current || d.lastReloadSuccessful {
+				t.Error("the current config wasn't kept")
+			}
+		})
+	}
+}
+
+func TestApplyConfigKeepsOutputs(t *testing.T) {
+	const base = `
+scripts:
+  - name: check_true
+    path: /bin/true
+    output_type: exit_code
+    group: {{group}}
+    args: [{{arg}}]
+outputs:
+  - name: textfile
+    type: textfile
+    directory: {{dir}}
+    split_by: group
+  - name: single
+    type: textfile
+    path: {{dir}}/single.prom
+  - name: statsd
+    type: statsd
+    address: {{statsd}}
+`
+	tests := []struct {
+		name        string
+		replacer    []string
+		wantRebuilt []string
+	}{
+		{name: "unchanged", replacer: []string{"{{group}}", "system", "{{arg}}", "a", "{{statsd}}", "127.0.0.1:8125"}},
+		{name: "script changed", replacer: []string{"{{group}}", "system", "{{arg}}", "b", "{{statsd}}", "127.0.0.1:8125"}},
+		{name: "group changed", replacer: []string{"{{group}}", "network", "{{arg}}", "a", "{{statsd}}", "127.0.0.1:8125"}, wantRebuilt: []string{"textfile"}},
+		{name: "output changed", replacer: []string{"{{group}}", "system", "{{arg}}", "a", "{{statsd}}", "127.0.0.1:8126"}, wantRebuilt: []string{"statsd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.yml")
+			write := func(replacer ...string) {
+				content := strings.NewReplacer(append(replacer, "{{dir}}", dir)...).Replace(base)
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			write("{{group}}", "system", "{{arg}}", "a", "{{statsd}}", "127.0.0.1:8125")
+
+			cfg := ExecutorConfig{ConfigFilePath: path, Interval: time.Minute}
+			cnf, err := config.Load(path)
+			if err != nil {
+				t.Fatalf("could not load the config: %v", err)
+			}
+			outputs, err := newOutputs(cfg, cnf)
+			if err != nil {
+				t.Fatalf("could not set up the outputs: %v", err)
+			}
+			defer func() { outputs.Close() }()
+			stats := map[string]sink.WriteStats{}
+			previous := map[string]sink.Sink{}
+			for _, s := range outputs.Sinks() {
+				stats[s.Name()] = sink.WriteStats{LastSuccess: true, Successes: 3, Failures: 1}
+				previous[s.Name()] = s
+			}
+			outputs.RestoreStats(stats)
+			d := &daemon{cfg: cfg, cnf: cnf, outputs: outputs, results: newResultStore(), groups: newGroupLimiter(cnf.ConcurrencyGroups), sched: newScheduler(cnf, true)}
+
+			write(tt.replacer...)
+			if err := d.applyConfig(); err != nil {
+				t.Fatalf("could not apply the config: %v", err)
+			}
+			outputs = d.outputs
+
+			rebuilt := []string{}
+			for _, s := range d.outputs.Sinks() {
+				if s != previous[s.Name()] {
+					rebuilt = append(rebuilt, s.Name())
+				}
+			}
+			if strings.Join(rebuilt, ",") != strings.Join(tt.wantRebuilt, ",") {
+				t.Errorf("expected the outputs %v to be rebuilt, got %v", tt.wantRebuilt, rebuilt)
+			}
+			if got := d.outputs.Stats(); !reflect.DeepEqual(got, stats) {
+				t.Errorf("expected the write stats %+v to be carried over, got %+v", stats, got)
+			}
+		})
+	}
+}
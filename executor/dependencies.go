@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"github.com/hartfordfive/n2p-script-executor/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// dependencyGraph tracks the completion of the scripts during an execution, so that a
+// script is only submitted once every script it depends on has succeeded, and is skipped
+// as soon as one of them has failed or was skipped.  Scripts are identified by execution
+// key, and a script with targets has succeeded once its executions for every target have.
+type dependencyGraph struct {
+	order    []string
+	scripts  map[string]config.Script
+	children map[string][]string
+	// waiting is the number of parents of each script which haven't succeeded yet, or
+	// -1 once the script is skipped
+	waiting map[string]int
+}
+
+func newDependencyGraph(scripts []config.Script) *dependencyGraph {
+	g := &dependencyGraph{
+		scripts:  map[string]config.Script{},
+		children: map[string][]string{},
+		waiting:  map[string]int{},
+	}
+	keys := map[string][]string{}
+	for _, s := range scripts {
+		keys[s.Name] = append(keys[s.Name], executionKey(s.Path, s.Target))
+	}
+	// Only the scripts being executed are waited for
+	for _, s := range scripts {
+		key := executionKey(s.Path, s.Target)
+		g.order = append(g.order, key)
+		g.scripts[key] = s
+		for _, parent := range s.DependsOn {
+			for _, parentKey := range keys[parent] {
+				g.waiting[key]++
+				g.children[parentKey] = append(g.children[parentKey], key)
+			}
+		}
+	}
+	return g
+}
+
+// roots returns the scripts which don't depend on any other script
+func (g *dependencyGraph) roots() []config.Script {
+	roots := []config.Script{}
+	for _, key := range g.order {
+		if g.waiting[key] == 0 {
+			roots = append(roots, g.scripts[key])
+		}
+	}
+	return roots
+}
+
+// complete records the completion of the script and returns the scripts which are now
+// ready to be executed, and the ones skipped because the script failed
+func (g *dependencyGraph) complete(key string, success bool) ([]config.Script, []config.Script) {
+	ready := []config.Script{}
+	skipped := []config.Script{}
+	for _, child := range g.children[key] {
+		if g.waiting[child] < 0 {
+			continue
+		}
+		if !success {
+			parent, script := g.scripts[key], g.scripts[child]
+			log.Warnf("Skipping script %s%s as script %s%s it depends on didn't succeed",
+				script.Path,
+				targetSuffix(script.Target),
+				parent.Path,
+				targetSuffix(parent.Target))
+			g.waiting[child] = -1
+			skipped = append(skipped, g.scripts[child])
+			_, descendants := g.complete(child, false)
+			skipped = append(skipped, descendants...)
+			continue
+		}
+		g.waiting[child]--
+		if g.waiting[child] == 0 {
+			ready = append(ready, g.scripts[child])
+		}
+	}
+	return ready, skipped
+}
@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	script := func(name string, dependsOn ...string) config.Script {
+		return config.Script{Name: name, Path: "/plugins/" + name, DependsOn: dependsOn}
+	}
+	target := func(s config.Script, target string) config.Script {
+		s.Target = target
+		return s
+	}
+	// a <- b <- d, a <- c, and e has the targets x and y with f depending on it
+	scripts := []config.Script{
+		script("a"),
+		script("b", "a"),
+		script("c", "a"),
+		script("d", "b"),
+		target(script("e"), "x"),
+		target(script("e"), "y"),
+		script("f", "e"),
+	}
+	type completion struct {
+		name    string
+		target  string
+		success bool
+	}
+
+	tests := []struct {
+		name        string
+		completions []completion
+		wantReady   []string
+		wantSkipped []string
+	}{
+		{
+			name:        "chain succeeds",
+			completions: []completion{{"a", "", true}, {"b", "", true}},
+			wantReady:   []string{"b", "c", "d"},
+		},
+		{
+			name:        "failure skips the descendants",
+			completions: []completion{{"a", "", false}},
+			wantSkipped: []string{"b", "c", "d"},
+		},
+		{
+			name:        "failure of a single branch",
+			completions: []completion{{"a", "", true}, {"b", "", false}},
+			wantReady:   []string{"b", "c"},
+			wantSkipped: []string{"d"},
+		},
+		{
+			name:        "every target must succeed",
+			completions: []completion{{"e", "x", true}, {"e", "y", true}},
+			wantReady:   []string{"f"},
+		},
+		{
+			name:        "one target fails",
+			completions: []completion{{"e", "x", true}, {"e", "y", false}},
+			wantSkipped: []string{"f"},
+		},
+		{
+			name:        "skipped once",
+			completions: []completion{{"e", "x", false}, {"e", "y", false}},
+			wantSkipped: []string{"f"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newDependencyGraph(scripts)
+			if got := scriptNames(g.roots()); !reflect.DeepEqual(got, []string{"a", "e", "e"}) {
+				t.Fatalf("roots() = %v, want [a e e]", got)
+			}
+
+			ready, skipped := []string{}, []string{}
+			for _, c := range tt.completions {
+				r, s := g.complete(executionKey("/plugins/"+c.name, c.target), c.success)
+				ready = append(ready, scriptNames(r)...)
+				skipped = append(skipped, scriptNames(s)...)
+			}
+			sort.Strings(ready)
+			sort.Strings(skipped)
+			if want := append([]string{}, tt.wantReady...); !reflect.DeepEqual(ready, want) {
+				t.Errorf("ready = %v, want %v", ready, want)
+			}
+			if want := append([]string{}, tt.wantSkipped...); !reflect.DeepEqual(skipped, want) {
+				t.Errorf("skipped = %v, want %v", skipped, want)
+			}
+		})
+	}
+}
+
+func scriptNames(scripts []config.Script) []string {
+	names := []string{}
+	for _, s := range scripts {
+		names = append(names, s.Name)
+	}
+	return names
+}
@@ -1,11 +1,12 @@
 package executor
 
 import (
-	"fmt"
 	"os"
+	"time"
 
 	"github.com/hartfordfive/n2p-script-executor/config"
 	"github.com/hartfordfive/n2p-script-executor/lib"
+	"github.com/hartfordfive/n2p-script-executor/sink"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -15,9 +16,11 @@ type ExecutorConfig struct {
 	ConfigFilePath string
 	LogLevel       string
 	Simulate       bool
+	Interval       time.Duration
+	ListenAddress  string
 }
 
-// Run runs the executor
+// Run runs the executor, either once or continuously when an interval is specified
 func Run(cfg ExecutorConfig) {
 
 	cnf, err := config.Load(cfg.ConfigFilePath)
@@ -26,73 +29,241 @@ func Run(cfg ExecutorConfig) {
 		os.Exit(1)
 	}
 
-	numWorkers := 4
-	if len(cnf.Scripts) < 4 {
-		numWorkers = len(cnf.Scripts)
-	}
-
 	if len(cnf.SeriesPrefix) >= 1 {
 		lib.SetSeriesPrefix(cnf.SeriesPrefix)
 	}
 
-	work := NewWorkQueue(4, numWorkers, len(cnf.Scripts))
-	log.Info("Starting script execution workers...")
-	work.Process()
+	if err := SetupCgroups(cnf.Cgroups); err != nil {
+		log.Errorf("Could not set up cgroups: %v", err)
+		os.Exit(1)
+	}
 
-	log.Info("Submitting scripts to be executed")
-	for _, s := range cnf.Scripts {
-		work.SubmitTask(s)
+	outputs, err := newOutputs(cfg, cnf)
+	if err != nil {
+		log.Errorln(err)
+		os.Exit(1)
+	}
+
+	if cfg.Interval > 0 {
+		runDaemon(cfg, cnf, outputs)
+		return
+	}
+	if cfg.ListenAddress != "" {
+		log.Warn("The management API is only served in daemon mode, ignoring the listen address")
+	}
+
+	results := loadResultStore(cnf.StateFile)
+	outputs.RestoreStats(results.Outputs)
+	sched := newScheduler(cnf, false)
+	now := time.Now()
+	due := sched.due(cnf.Scripts, now)
+	series := sched.complete(cnf.Scripts, due, execute(cnf, due, results, newGroupLimiter(cnf.ConcurrencyGroups), 0), now)
+	if len(series) == 0 {
+		outputs.Close()
+		saveState(cfg, cnf, results)
+		os.Exit(1)
 	}
 
+	log.Infof("Writing resulting series to %s", outputs.Name())
+	outputs.Write(series)
+	outputs.Close()
+	results.Outputs = outputs.Stats()
+	saveState(cfg, cnf, results)
+	os.Exit(0)
+}
+
+// saveState writes the results to the state file, when one is configured and the
+// executor isn't only simulating
+func saveState(cfg ExecutorConfig, cnf *config.Config, results *resultStore) {
+	if cnf.StateFile == "" || cfg.Simulate {
+		return
+	}
+	if err := results.save(cnf.StateFile, cnf.Scripts); err != nil {
+		log.Errorf("Could not write state file %s: %v", cnf.StateFile, err)
+	}
+}
+
+// newOutputs returns the fan-out to the outputs the resulting series are written to
+func newOutputs(cfg ExecutorConfig, cnf *config.Config) (*sink.Fanout, error) {
+	outputs := outputConfigs(cfg, cnf)
+	sinks := make([]sink.Sink, 0, len(outputs))
+	for _, out := range outputs {
+		s, err := sink.New(out, cnf.Scripts, cfg.Interval > 0)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sink.NewFanout(sinks), nil
+}
+
+// outputConfigs returns the outputs the resulting series are written to. The output file
+// from the command line is written along with the outputs from the config, and stdout is
+// used when neither is specified or when only simulating.
+func outputConfigs(cfg ExecutorConfig, cnf *config.Config) []config.Output {
+	stdout := config.Output{Name: "stdout", Type: "stdout", Protocol: "stdout"}
+
+	if cfg.Simulate {
+		return []config.Output{stdout}
+	}
+
+	outputs := cnf.Outputs
+	if cfg.OutputFilePath != "" {
+		outputs = append([]config.Output{{
+			Name:     "output_file",
+			Type:     "textfile",
+			Protocol: "file",
+			Path:     cfg.OutputFilePath,
+			FileMode: "0644",
+		}}, outputs...)
+	}
+	if len(outputs) == 0 {
+		return []config.Output{stdout}
+	}
+	return outputs
+}
+
+// execute runs the scripts, once per target for the scripts with targets, and returns the
+// resulting series. The failure policy of each script is applied using the results of its
+// previous executions.  Each script is executed after its splay delay, limited to fit in
+// the interval when it's set, and once a slot of its concurrency group is available in groups.
+func execute(cnf *config.Config, due []config.Script, results *resultStore, groups *groupLimiter, interval time.Duration) []lib.Metric {
+
+	toRun := expandTargets(due)
+	results.keepTargets(due, toRun)
+
+	scripts := make(map[string]config.Script, len(toRun))
+	for _, s := range toRun {
+		scripts[executionKey(s.Path, s.Target)] = s
+	}
+
+	numWorkers := cnf.MaxConcurrency
+	if len(toRun) < numWorkers {
+		numWorkers = len(toRun)
+	}
+
+	work := newWorkQueue(numWorkers, groups, len(toRun))
+	// The scripts being executed through the management API are waited for
+	work.runs = results
+	log.Info("Starting script execution workers...")
+	work.Process()
+	defer work.Shutdown()
+
 	var series []lib.Metric
 	scriptLoadedSeries := []lib.Metric{}
 	scriptExecSuccessSeries := []lib.Metric{}
-	var execSuccess = make([]string, 0, len(cnf.Scripts))
+	var execSuccess = make([]map[string]string, 0, len(toRun))
 
-	go func(execSuccess *[]string) {
+	deps := newDependencyGraph(toRun)
+
+	go func(execSuccess *[]map[string]string) {
 		log.Info("Waiting for results...")
 
-		for res := range work.ResultsChan {
+		record := func(res ExecutionResult) {
+			results.recordRun(res)
+			script := scripts[executionKey(res.ScriptPath, res.Target)]
+
+			scriptLoadedSeries = append(scriptLoadedSeries, lib.Metric{
+				Name:   "script_loaded",
+				Labels: seriesLabels(script),
+				Value:  1.0,
+				Type:   "gauge",
+				Help:   "indicates that a script has been identified to be executed",
+				Source: res.ScriptPath,
+			})
+
+			if len(script.DependsOn) > 0 {
+				skipped := 0.0
+				if res.Skipped {
+					skipped = 1.0
+				}
+				scriptExecSuccessSeries = append(scriptExecSuccessSeries, lib.Metric{
+					Name:   "script_last_run_skipped",
+					Labels: seriesLabels(script),
+					Value:  skipped,
+					Type:   "gauge",
+					Help:   "indicates when a script was skipped as a script it depends on didn't succeed",
+					Source: res.ScriptPath,
+				})
+			}
+			if res.Skipped {
+				return
+			}
+
+			scriptLoadedSeries = append(scriptLoadedSeries, lib.Metric{
+				Name:   "script_last_execution_time_ms",
+				Labels: seriesLabels(script),
+				Value:  float64(res.TotalExecTime),
+				Type:   "gauge",
+				Help:   "indicates the number of milliseconds it has taken to execute the script",
+				Source: res.ScriptPath,
+			})
 
 			scriptLoadedSeries = append(scriptLoadedSeries, lib.Metric{
-				Name: "script_loaded",
-				Labels: map[string]string{
-					"script": res.ScriptPath,
-				},
-				Value: 1.0,
-				Type:  "gauge",
-				Help:  "indicates that a script has been identified to be executed",
+				Name:   "script_last_queue_wait_time_ms",
+				Labels: seriesLabels(script),
+				Value:  float64(res.QueueWaitTime),
+				Type:   "gauge",
+				Help:   "indicates the number of milliseconds the script has waited in the queue before being executed",
+				Source: res.ScriptPath,
 			})
 
 			scriptLoadedSeries = append(scriptLoadedSeries, lib.Metric{
-				Name: "script_last_execution_time_ms",
-				Labels: map[string]string{
-					"script": res.ScriptPath,
-				},
-				Value: float64(res.TotalExecTime),
-				Type:  "gauge",
-				Help:  "indicates the number of milliseconds it has taken to execute the script",
+				Name:   "script_last_attempts",
+				Labels: seriesLabels(script),
+				Value:  float64(res.Attempts),
+				Type:   "gauge",
+				Help:   "indicates the number of attempts it has taken to execute the script",
+				Source: res.ScriptPath,
 			})
 
+			if res.Usage != nil {
+				scriptLoadedSeries = append(scriptLoadedSeries, usageSeries(res, script)...)
+			}
+			if res.Cgroup != nil {
+				scriptLoadedSeries = append(scriptLoadedSeries, cgroupSeries(res, script)...)
+			}
+
 			lastRunSuccess := 0.0
 
 			if res.Error == nil {
 				for _, metric := range res.Metrics {
+					metric.Source = res.ScriptPath
 					series = append(series, metric)
 				}
-				*execSuccess = append(*execSuccess, res.ScriptPath)
+				*execSuccess = append(*execSuccess, seriesLabels(script))
 				lastRunSuccess = 1.0
+				results.update(script, res.Metrics)
+			} else {
+				for _, metric := range results.onFailure(script) {
+					metric.Source = res.ScriptPath
+					series = append(series, metric)
+				}
 			}
 
+			scriptExecSuccessSeries = append(scriptExecSuccessSeries, failureSeries(res, script, results)...)
+
 			scriptExecSuccessSeries = append(scriptExecSuccessSeries, lib.Metric{
-				Name: "script_last_run_success",
-				Labels: map[string]string{
-					"script": res.ScriptPath,
-				},
-				Value: lastRunSuccess,
-				Type:  "gauge",
-				Help:  "iindicates when a script was last executed successfully",
+				Name:   "script_last_run_success",
+				Labels: seriesLabels(script),
+				Value:  lastRunSuccess,
+				Type:   "gauge",
+				Help:   "iindicates when a script was last executed successfully",
+				Source: res.ScriptPath,
 			})
+		}
+
+		for res := range work.ResultsChan {
+			ready, skipped := deps.complete(executionKey(res.ScriptPath, res.Target), res.Error == nil)
+			record(res)
+			for _, s := range skipped {
+				record(ExecutionResult{ScriptPath: s.Path, ScriptName: s.Name, Target: s.Target, Skipped: true})
+			}
+			// Scripts are submitted before the completed one is marked done, so the wait
+			// group doesn't reach zero while dependent scripts are left to execute
+			for _, s := range ready {
+				work.SubmitTaskAfter(s, splayDelay(s, interval))
+			}
 
 			log.Debug("Decrementing waitgroup")
 			work.Wg.Done()
@@ -102,8 +273,14 @@ func Run(cfg ExecutorConfig) {
 
 	}(&execSuccess)
 
+	log.Info("Submitting scripts to be executed")
+	for _, s := range deps.roots() {
+		work.SubmitTaskAfter(s, splayDelay(s, interval))
+	}
+
 	log.Info("Waiting for all script executions to be completed...")
 	work.Wg.Wait()
+	close(work.ResultsChan)
 
 	for _, res := range scriptLoadedSeries {
 		series = append(series, res)
@@ -113,27 +290,121 @@ func Run(cfg ExecutorConfig) {
 		series = append(series, res)
 	}
 
-	seriesOutput := lib.GenerateSeries(series, execSuccess)
+	return append(series, lib.ExecutorSeries(execSuccess)...)
+}
 
-	if cfg.OutputFilePath != "" {
-		log.Infof("Writing resulting series to %s", cfg.OutputFilePath)
-	} else {
-		log.Info("Writing resulting series to stdout")
+// seriesLabels returns the labels of the series describing an execution of the script,
+// which include the target and its labels for the executions expanded from its targets
+func seriesLabels(script config.Script) map[string]string {
+	labels := map[string]string{"script": script.Path}
+	if script.Target != "" {
+		for k, v := range script.TargetLabels {
+			labels[k] = v
+		}
+		labels["target"] = script.Target
 	}
-	if !cfg.Simulate {
-		if len(series) >= 1 {
-			// Write the series to the output file
-			lib.WriteToFile(cfg.OutputFilePath, seriesOutput)
-			os.Exit(0)
+	return labels
+}
 
+// failureSeries returns the state series of the reason of the failure of the script, and
+// the counters of its failures by reason when they are counted
+func failureSeries(res ExecutionResult, script config.Script, results *resultStore) []lib.Metric {
+	reason := ""
+	if res.Error != nil {
+		reason = failureReason(res.Error)
+	}
+
+	series := make([]lib.Metric, 0, 2*len(failureReasons))
+	for _, r := range failureReasons {
+		value := 0.0
+		if r == reason {
+			value = 1.0
 		}
-		os.Exit(1)
+		labels := seriesLabels(script)
+		labels["reason"] = r
+		series = append(series, lib.Metric{
+			Name:   "script_last_failure_reason",
+			Labels: labels,
+			Value:  value,
+			Type:   "gauge",
+			Help:   "indicates the reason of the failure of the last execution of the script",
+			Source: res.ScriptPath,
+		})
 	}
 
-	fmt.Fprintf(os.Stdout, seriesOutput)
-	if len(series) >= 1 {
-		os.Exit(0)
+	counts := results.recordFailures(executionKey(res.ScriptPath, res.Target), reason)
+	if counts == nil {
+		return series
 	}
+	for _, r := range failureReasons {
+		labels := seriesLabels(script)
+		labels["reason"] = r
+		series = append(series, lib.Metric{
+			Name:   "script_failures_total",
+			Labels: labels,
+			Value:  counts[r],
+			Type:   "counter",
+			Help:   "indicates the number of failed executions of the script by reason",
+			Source: res.ScriptPath,
+		})
+	}
+	return series
+}
+
+// usageSeries returns the series of the resource usage of the script process
+func usageSeries(res ExecutionResult, script config.Script) []lib.Metric {
+	usage := []struct {
+		name  string
+		value float64
+		help  string
+	}{
+		{"script_last_cpu_user_seconds", res.Usage.CPUUserSeconds, "indicates the user CPU time used by the script"},
+		{"script_last_cpu_system_seconds", res.Usage.CPUSystemSeconds, "indicates the system CPU time used by the script"},
+		{"script_last_max_rss_bytes", res.Usage.MaxRSSBytes, "indicates the maximum resident set size of the script"},
+		{"script_last_voluntary_context_switches", res.Usage.VoluntaryCtxSwitches, "indicates the number of voluntary context switches of the script"},
+		{"script_last_involuntary_context_switches", res.Usage.InvoluntaryCtxSwitches, "indicates the number of involuntary context switches of the script"},
+		{"script_last_block_input_operations", res.Usage.BlockInputOps, "indicates the number of block input operations of the script"},
+		{"script_last_block_output_operations", res.Usage.BlockOutputOps, "indicates the number of block output operations of the script"},
+		{"script_last_terminating_signal", float64(res.Usage.Signal), "indicates the signal which terminated the script, 0 when it exited"},
+	}
+
+	series := make([]lib.Metric, 0, len(usage))
+	for _, u := range usage {
+		series = append(series, lib.Metric{
+			Name:   u.name,
+			Labels: seriesLabels(script),
+			Value:  u.value,
+			Type:   "gauge",
+			Help:   u.help,
+			Source: res.ScriptPath,
+		})
+	}
+	return series
+}
 
-	os.Exit(1)
+// cgroupSeries returns the series of the resource usage accounted by the cgroup of the script
+func cgroupSeries(res ExecutionResult, script config.Script) []lib.Metric {
+	usage := []struct {
+		name  string
+		value float64
+		help  string
+	}{
+		{"script_last_cgroup_memory_peak_bytes", res.Cgroup.MemoryPeakBytes, "indicates the peak memory usage of the script, accounted by its cgroup"},
+		{"script_last_cgroup_cpu_user_seconds", res.Cgroup.CPUUserSeconds, "indicates the user CPU time used by the script, accounted by its cgroup"},
+		{"script_last_cgroup_cpu_system_seconds", res.Cgroup.CPUSystemSeconds, "indicates the system CPU time used by the script, accounted by its cgroup"},
+		{"script_last_cgroup_cpu_throttled_seconds", res.Cgroup.CPUThrottledSeconds, "indicates the time the script has been throttled by the cpu.max limit of its cgroup"},
+	}
+
+	series := make([]lib.Metric, 0, len(usage))
+	for _, u := range usage {
+		series = append(series, lib.Metric{
+			Name:   u.name,
+			Labels: seriesLabels(script),
+			Value:  u.value,
+			Type:   "gauge",
+			Help:   u.help,
+			Source: res.ScriptPath,
+		})
+	}
+	return series
 }
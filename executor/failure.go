@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// Reasons of the failure of a script execution
+const (
+	ReasonTimeout         = "timeout"
+	ReasonExecFailed      = "exec_failed"
+	ReasonNonzeroExit     = "nonzero_exit"
+	ReasonParseError      = "parse_error"
+	ReasonNoSeries        = "no_series"
+	ReasonLimitExceeded   = "limit_exceeded"
+	ReasonKilledBySignal  = "killed_by_signal"
+	ReasonOutputTruncated = "output_truncated"
+)
+
+var failureReasons = []string{
+	ReasonTimeout,
+	ReasonExecFailed,
+	ReasonNonzeroExit,
+	ReasonParseError,
+	ReasonNoSeries,
+	ReasonLimitExceeded,
+	ReasonKilledBySignal,
+	ReasonOutputTruncated,
+}
+
+// ExecutionError is the error returned for a failed script execution, along with the
+// reason of the failure
+type ExecutionError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ExecutionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+func newExecutionError(reason string, err error) error {
+	return &ExecutionError{Reason: reason, Err: err}
+}
+
+// exitFailureReason returns the reason of the failure of a script which exited with an error
+func exitFailureReason(script config.Script, err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ReasonExecFailed
+	}
+	if waitStatus, ok := waitStatus(script, exitErr.ProcessState); ok && waitStatus.Signaled() {
+		return ReasonKilledBySignal
+	}
+	return ReasonNonzeroExit
+}
+
+// failureReason returns the reason of the failure of a script execution.  Errors which
+// weren't classified happened before the script could be executed.
+func failureReason(err error) string {
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		return ReasonLimitExceeded
+	}
+	var execErr *ExecutionError
+	if errors.As(err, &execErr) {
+		return execErr.Reason
+	}
+	return ReasonExecFailed
+}
@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestRunScriptAttemptFailureReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		command    string
+		outputType string
+		limits     config.Limits
+		timeout    time.Duration
+		wantReason string
+	}{
+		{name: "exit_code script exiting nonzero", command: "exit 2", outputType: "exit_code"},
+		{name: "stdout script exiting nonzero", command: "echo 1; exit 2", outputType: "stdout", wantReason: ReasonNonzeroExit},
+		{name: "stdout parse error", command: "echo OK", outputType: "stdout", wantReason: ReasonParseError},
+		{name: "raw_series without series", command: "echo", outputType: "raw_series", wantReason: ReasonNoSeries},
+		{name: "timeout", command: "sleep 5", outputType: "exit_code", timeout: 200 * time.Millisecond, wantReason: ReasonTimeout},
+		{name: "exit_code script killed by a signal", command: "kill -TERM $$", outputType: "exit_code", wantReason: ReasonKilledBySignal},
+		{name: "stdout script killed by a signal", command: "kill -KILL $$", outputType: "stdout", wantReason: ReasonKilledBySignal},
+		{name: "SIGXCPU with a CPU limit", command: "kill -XCPU $$", outputType: "exit_code", limits: config.Limits{CPUSeconds: 5}, wantReason: ReasonLimitExceeded},
+		{name: "SIGXCPU without a CPU limit", command: "kill -XCPU $$", outputType: "exit_code", wantReason: ReasonKilledBySignal},
+		{name: "truncated output", command: "echo 123456789012345", outputType: "stdout", wantReason: ReasonOutputTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 10 * time.Second
+			}
+			// The limits are set up before the script is executed with exec, which can't
+			// execute eval
+			path := filepath.Join(t.TempDir(), "check")
+			if err := os.WriteFile(path, []byte("#!/bin/bash\n"+tt.command+"\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			script := config.Script{
+				Path:           path,
+				OutputType:     tt.outputType,
+				Limits:         tt.limits,
+				KillGrace:      "1s",
+				MaxOutputBytes: 10,
+			}
+			res := runScriptAttempt(script, timeout)
+
+			if tt.wantReason == "" {
+				if res.Error != nil {
+					t.Errorf("unexpected error: %v", res.Error)
+				}
+				return
+			}
+			if res.Error == nil {
+				t.Fatalf("expected an error with reason %s", tt.wantReason)
+			}
+			if got := failureReason(res.Error); got != tt.wantReason {
+				t.Errorf("reason = %s, want %s (error: %v)", got, tt.wantReason, res.Error)
+			}
+		})
+	}
+}
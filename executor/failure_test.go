@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestFailureSeries(t *testing.T) {
+	tests := []struct {
+		name       string
+		res        ExecutionResult
+		wantReason string
+	}{
+		{name: "success", res: ExecutionResult{}},
+		{name: "timeout", res: ExecutionResult{Error: newExecutionError(ReasonTimeout, errors.New("Script execution timed out"))}, wantReason: ReasonTimeout},
+		{name: "setup failure", res: ExecutionResult{Error: newExecutionError(ReasonExecFailed, errors.New("Could not set up the execution of the script"))}, wantReason: ReasonExecFailed},
+		{name: "unclassified error", res: ExecutionResult{Error: errors.New("Could not set up cgroup")}, wantReason: ReasonExecFailed},
+		{name: "nonzero exit", res: ExecutionResult{Error: newExecutionError(ReasonNonzeroExit, errors.New("exit status 2"))}, wantReason: ReasonNonzeroExit},
+		{name: "parse error", res: ExecutionResult{Error: newExecutionError(ReasonParseError, errors.New("Could not parse script output as float"))}, wantReason: ReasonParseError},
+		{name: "no series", res: ExecutionResult{Error: newExecutionError(ReasonNoSeries, errors.New("No valid series detected"))}, wantReason: ReasonNoSeries},
+		{name: "limit exceeded", res: ExecutionResult{Error: &LimitExceededError{Limit: "cpu_seconds", Detail: "used 5.01s of CPU"}}, wantReason: ReasonLimitExceeded},
+		{name: "wrapped limit exceeded", res: ExecutionResult{Error: fmt.Errorf("attempt failed: %w", &LimitExceededError{Limit: "cpu_seconds"})}, wantReason: ReasonLimitExceeded},
+		{name: "killed by signal", res: ExecutionResult{Error: newExecutionError(ReasonKilledBySignal, errors.New("script was terminated by killed"))}, wantReason: ReasonKilledBySignal},
+		{name: "output truncated", res: ExecutionResult{Error: newExecutionError(ReasonOutputTruncated, errors.New("Output of script exceeded 10 bytes"))}, wantReason: ReasonOutputTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := config.Script{Path: "/plugins/check_disk"}
+			tt.res.ScriptPath = script.Path
+			results := newResultStore()
+			results.countFailures()
+
+			// The failures are counted over two executions
+			failureSeries(tt.res, script, results)
+			series := failureSeries(tt.res, script, results)
+			if len(series) != 2*len(failureReasons) {
+				t.Fatalf("got %d series, want %d", len(series), 2*len(failureReasons))
+			}
+			for _, metric := range series {
+				reason := metric.Labels["reason"]
+				want := 0.0
+				switch {
+				case metric.Name == "script_last_failure_reason" && reason == tt.wantReason:
+					want = 1
+				case metric.Name == "script_failures_total" && reason == tt.wantReason:
+					want = 2
+				}
+				if metric.Value != want {
+					t.Errorf("%s{reason=%q} = %v, want %v", metric.Name, reason, metric.Value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExitFailureReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{name: "nonzero exit", command: "exit 2", want: ReasonNonzeroExit},
+		{name: "signal", command: "kill -TERM $$", want: ReasonKilledBySignal},
+		{name: "exit code of a signal", command: "exit 143", want: ReasonNonzeroExit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := exec.Command("/bin/bash", "-c", tt.command).Run()
+			if got := exitFailureReason(config.Script{Path: "/bin/check"}, err); got != tt.want {
+				t.Errorf("exitFailureReason() = %s, want %s (error: %v)", got, tt.want, err)
+			}
+		})
+	}
+
+	if got := exitFailureReason(config.Script{Path: "/bin/check"}, errors.New("broken pipe")); got != ReasonExecFailed {
+		t.Errorf("exitFailureReason() of an error other than an exit = %s, want %s", got, ReasonExecFailed)
+	}
+}
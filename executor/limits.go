@@ -0,0 +1,122 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// LimitExceededError is the error returned when a script was terminated for exceeding
+// one of its resource limits
+type LimitExceededError struct {
+	Limit  string
+	Detail string
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("Script exceeded its %s limit (%s)", e.Limit, e.Detail)
+}
+
+// setupErrorsFd is the file descriptor of the pipe the errors setting up the execution of
+// a script are reported on, so they aren't mistaken for the exit code of the script
+const setupErrorsFd = 3
+
+// scriptCommand returns the command passed to bash to execute the script with its args,
+// which are quoted so they reach the script unchanged. When resource limits are
+// configured, they are set with ulimit (setrlimit) in the child shell which then replaces
+// itself with the script, so the limits apply to the script and everything it starts.
+// The errors of ulimit are reported on setupErrorsFd, which the script doesn't inherit.
+func scriptCommand(script config.Script) string {
+	limits := script.Limits
+	ulimits := []string{}
+	if limits.CPUSeconds > 0 {
+		// The soft limit sends SIGXCPU, the hard limit one second later sends SIGKILL
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -S -t %d", limits.CPUSeconds))
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -H -t %d", limits.CPUSeconds+1))
+	}
+	if limits.AddressSpaceMB > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -v %d", limits.AddressSpaceMB*1024))
+	}
+	if limits.OpenFiles > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -n %d", limits.OpenFiles))
+	}
+	if limits.Processes > 0 {
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -u %d", limits.Processes))
+	}
+
+	command := script.Path
+	for _, arg := range script.Args {
+		command += " " + shellQuote(arg)
+	}
+
+	if len(ulimits) == 0 {
+		return command
+	}
+	return fmt.Sprintf("{ %s; } 2>&%d || exit 126; exec %s %d>&-", strings.Join(ulimits, " && "), setupErrorsFd, command, setupErrorsFd)
+}
+
+// hasLimits returns true when resource limits are set with ulimit for the script
+func hasLimits(script config.Script) bool {
+	l := script.Limits
+	return l.CPUSeconds > 0 || l.AddressSpaceMB > 0 || l.OpenFiles > 0 || l.Processes > 0
+}
+
+// shellQuote quotes the argument so it's passed as is to the script by the shell
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// setupErrors reads the errors reported on setupErrorsFd while setting up the execution
+// of a script
+type setupErrors struct {
+	r *os.File
+}
+
+// err returns the error reported while setting up the execution of the script, if any.
+// It must be called once the script has completed, and releases the pipe.
+func (s *setupErrors) err() error {
+	if s == nil || s.r == nil {
+		return nil
+	}
+	defer s.close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(s.r, maxOutputSnippet))
+	if len(msg) == 0 {
+		return nil
+	}
+	return newExecutionError(ReasonExecFailed, fmt.Errorf("Could not set up the execution of the script: %s", strings.TrimSpace(string(msg))))
+}
+
+func (s *setupErrors) close() {
+	if s != nil && s.r != nil {
+		s.r.Close()
+		s.r = nil
+	}
+}
+
+// startWithLimits starts the command and applies the scheduling priorities of the
+// script to its process group.  When the script has resource limits, the returned
+// setupErrors holds the errors setting them.
+func startWithLimits(cmd *exec.Cmd, script config.Script) (*setupErrors, error) {
+	setup := &setupErrors{}
+	if hasLimits(script) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		// The write end is only held by the child
+		defer w.Close()
+		cmd.ExtraFiles = []*os.File{w}
+		setup.r = r
+	}
+	if err := cmd.Start(); err != nil {
+		setup.close()
+		return nil, err
+	}
+	applyPriorities(cmd.Process.Pid, script)
+	return setup, nil
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ioprioWhoPgrp    = 2
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// applyPriorities sets the nice value and I/O scheduling class of the process group of
+// the script, which every process it starts inherits
+func applyPriorities(pgid int, script config.Script) {
+	if script.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PGRP, pgid, script.Nice); err != nil {
+			log.Warnf("Could not set nice value of script %s: %v", script.Path, err)
+		}
+	}
+	if class, ok := ioprioClasses[script.IONiceClass]; ok {
+		prio := class<<ioprioClassShift | script.IONiceLevel
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pgid), uintptr(prio)); errno != 0 {
+			log.Warnf("Could not set I/O scheduling class of script %s: %v", script.Path, errno)
+		}
+	}
+}
+
+// checkLimitExceeded returns a LimitExceededError when the script was terminated because
+// of its CPU time limit.  Exceeding the address space only makes allocations fail, which
+// can't be told apart from the other failures of the script.
+func checkLimitExceeded(script config.Script, state *os.ProcessState) error {
+	if state == nil || state.Success() {
+		return nil
+	}
+	waitStatus, ok := waitStatus(script, state)
+	if !ok {
+		return nil
+	}
+	limits := script.Limits
+
+	if limits.CPUSeconds > 0 {
+		cpu := state.UserTime() + state.SystemTime()
+		xcpu := (waitStatus.Signaled() && waitStatus.Signal() == syscall.SIGXCPU) ||
+			waitStatus.ExitStatus() == 128+int(syscall.SIGXCPU)
+		killed := waitStatus.Signaled() && waitStatus.Signal() == syscall.SIGKILL
+		if xcpu || (killed && cpu.Seconds() >= float64(limits.CPUSeconds)) {
+			return &LimitExceededError{Limit: "cpu_seconds", Detail: fmt.Sprintf("used %.2fs of CPU", cpu.Seconds())}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"os"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// applyPriorities is only supported on Linux
+func applyPriorities(pgid int, script config.Script) {
+	if script.Nice != 0 || script.IONiceClass != "" {
+		log.Warnf("nice and ionice settings of script %s are only supported on Linux", script.Path)
+	}
+}
+
+// checkLimitExceeded is only supported on Linux
+func checkLimitExceeded(script config.Script, state *os.ProcessState) error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestScriptCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		script config.Script
+		want   string
+	}{
+		{
+			name:   "no limits",
+			script: config.Script{Path: "/usr/lib/nagios/plugins/check_load", Args: []string{"-w", "5,4,3"}},
+			want:   "/usr/lib/nagios/plugins/check_load '-w' '5,4,3'",
+		},
+		{
+			name:   "quoted args",
+			script: config.Script{Path: "/bin/check", Args: []string{"it's", "$HOME", ""}},
+			want:   `/bin/check 'it'\''s' '$HOME' ''`,
+		},
+		{
+			name:   "cpu limit",
+			script: config.Script{Path: "/bin/check", Limits: config.Limits{CPUSeconds: 5}},
+			want:   "{ ulimit -S -t 5 && ulimit -H -t 6; } 2>&3 || exit 126; exec /bin/check 3>&-",
+		},
+		{
+			name: "all limits",
+			script: config.Script{
+				Path:   "/bin/check",
+				Args:   []string{"-v"},
+				Limits: config.Limits{AddressSpaceMB: 512, OpenFiles: 256, Processes: 64},
+			},
+			want: "{ ulimit -v 524288 && ulimit -n 256 && ulimit -u 64; } 2>&3 || exit 126; exec /bin/check '-v' 3>&-",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scriptCommand(tt.script); got != tt.want {
+				t.Errorf("scriptCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartWithLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		limits     config.Limits
+		wantReason string
+		wantExit   int
+	}{
+		{name: "no limits", path: "true"},
+		{name: "limits set", path: "true", limits: config.Limits{OpenFiles: 64}},
+		{name: "script exit code kept", path: "false", limits: config.Limits{OpenFiles: 64}, wantExit: 1},
+		// Above the nr_open maximum of the kernel, even for root
+		{name: "limit can't be set", path: "true", limits: config.Limits{OpenFiles: 1 << 30}, wantReason: ReasonExecFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := config.Script{Path: tt.path, Limits: tt.limits}
+			cmd := exec.Command("/bin/bash", "-c", scriptCommand(script))
+			setup, err := startWithLimits(cmd, script)
+			if err != nil {
+				t.Fatalf("startWithLimits() error = %v", err)
+			}
+			waitErr := cmd.Wait()
+			setupErr := setup.err()
+
+			if tt.wantReason != "" {
+				var execErr *ExecutionError
+				if !errors.As(setupErr, &execErr) || execErr.Reason != tt.wantReason {
+					t.Fatalf("setup error = %v, want reason %s", setupErr, tt.wantReason)
+				}
+				return
+			}
+			if setupErr != nil {
+				t.Fatalf("setup error = %v, want none", setupErr)
+			}
+			exitCode := 0
+			var exitErr *exec.ExitError
+			if errors.As(waitErr, &exitErr) {
+				exitCode = exitErr.ExitCode()
+			}
+			if exitCode != tt.wantExit {
+				t.Errorf("exit code = %d, want %d", exitCode, tt.wantExit)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package executor
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxOutputSnippet is the maximum size of the output of a script included in the logs
+// and the responses of the management API
+const maxOutputSnippet = 512
+
+// boundedBuffer is a writer keeping at most max bytes of the output of a script. The
+// output past the limit is discarded without failing the write, so that the script
+// isn't killed by a broken pipe.
+type boundedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	room := b.max - b.buf.Len()
+	if len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Bytes returns the output kept in the buffer
+func (b *boundedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// outputSnippet returns the beginning of the output of a script to include in the logs
+// and the responses of the management API
+func outputSnippet(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) <= maxOutputSnippet {
+		return output
+	}
+	// Cut on a rune boundary, so the snippet stays valid UTF-8
+	end := maxOutputSnippet
+	for end > 0 && !utf8.RuneStart(output[end]) {
+		end--
+	}
+	return output[:end] + "..."
+}
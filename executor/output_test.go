@@ -0,0 +1,116 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestBoundedBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		max           int
+		writes        []string
+		want          string
+		wantTruncated bool
+	}{
+		{name: "under the limit", max: 10, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "at the limit", max: 6, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "over the limit", max: 4, writes: []string{"abc", "def"}, want: "abcd", wantTruncated: true},
+		{name: "write after the limit", max: 3, writes: []string{"abc", "def", "ghi"}, want: "abc", wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &boundedBuffer{max: tt.max}
+			for _, w := range tt.writes {
+				if n, err := b.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := string(b.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+			if b.truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", b.truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestOutputSnippet(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "short", output: "  CRITICAL - disk full\n", want: "CRITICAL - disk full"},
+		{name: "at the limit", output: strings.Repeat("a", maxOutputSnippet), want: strings.Repeat("a", maxOutputSnippet)},
+		{name: "over the limit", output: strings.Repeat("a", maxOutputSnippet+1), want: strings.Repeat("a", maxOutputSnippet) + "..."},
+		// "é" is 2 bytes, so the limit falls in the middle of the last one
+		{name: "multi-byte runes", output: "a" + strings.Repeat("é", maxOutputSnippet), want: "a" + strings.Repeat("é", maxOutputSnippet/2-1) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := outputSnippet(tt.output)
+			if got != tt.want {
+				t.Errorf("outputSnippet() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("outputSnippet() = %q is not valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestRunScriptAttemptTruncatedOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		outputType  string
+		parseSource string
+		command     string
+		wantReason  string
+		wantMetrics int
+	}{
+		{name: "complete output", outputType: "raw_series", command: "echo 'a 1'; echo 'b 2'", wantMetrics: 2},
+		{name: "truncated output", outputType: "raw_series", command: "echo 'a 1'; echo 'b 22222222'", wantReason: ReasonOutputTruncated},
+		{name: "truncated parsed stderr", outputType: "raw_series", parseSource: "stderr", command: "echo 'a 1'; echo 'b 22222222' >&2", wantReason: ReasonOutputTruncated},
+		{name: "truncated other output", outputType: "raw_series", command: "echo 'a 1'; echo 'b 22222222' >&2", wantMetrics: 1},
+		{name: "truncated output of exit code", outputType: "exit_code", command: "echo 'b 22222222'; exit 2", wantMetrics: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := config.Script{
+				Path:           "eval",
+				Args:           []string{tt.command},
+				OutputType:     tt.outputType,
+				ParseSource:    tt.parseSource,
+				MaxOutputBytes: 10,
+			}
+			res := runScriptAttempt(script, 10*time.Second)
+
+			if tt.wantReason != "" {
+				var execErr *ExecutionError
+				if !errors.As(res.Error, &execErr) || execErr.Reason != tt.wantReason {
+					t.Fatalf("error = %v, want reason %s", res.Error, tt.wantReason)
+				}
+				if len(res.Metrics) != 0 {
+					t.Errorf("got %d metrics, want none", len(res.Metrics))
+				}
+				return
+			}
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			if len(res.Metrics) != tt.wantMetrics {
+				t.Errorf("got %d metrics, want %d", len(res.Metrics), tt.wantMetrics)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout of Prometheus, so the response
+// of a probe is sent before Prometheus gives up on the scrape
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// handleProbe executes the probe script named by the check parameter against the target
+// parameter, the way blackbox_exporter probes a target.  The target replaces {{target}}
+// in the args of the script, and the script is executed within the scrape timeout sent by
+// Prometheus, which includes the time waited for a slot of its concurrency group.  The
+// response holds the series of the probe, along with probe_success and
+// probe_duration_seconds.  Probes are rejected once max_concurrent_probes are in progress.
+func (d *daemon) handleProbe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	name := params.Get("check")
+	if name == "" {
+		http.Error(w, "check parameter is missing", http.StatusBadRequest)
+		return
+	}
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if err := config.ValidateTarget(target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.RLock()
+	script, found := config.Script{}, false
+	for _, s := range d.cnf.Scripts {
+		if s.Name == name && s.Probe {
+			script, found = s, true
+			break
+		}
+	}
+	slots := d.probeSlots
+	d.mu.RUnlock()
+	if !found {
+		http.Error(w, fmt.Sprintf("unknown check '%s', it must be the name of a probe script", name), http.StatusBadRequest)
+		return
+	}
+
+	timeout, err := probeTimeout(script, r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	default:
+		http.Error(w, fmt.Sprintf("too many probes in progress (max_concurrent_probes: %d)", cap(slots)), http.StatusServiceUnavailable)
+		return
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if !d.groups.acquire(ctx, script.ConcurrencyGroup) {
+		http.Error(w, fmt.Sprintf("no slot of concurrency group '%s' was available within the timeout of %v", script.ConcurrencyGroup, timeout), http.StatusServiceUnavailable)
+		return
+	}
+	defer d.groups.release(script.ConcurrencyGroup)
+
+	timeout -= time.Since(start)
+	log.Debugf("Probing target %s with script %s (timeout: %v)", target, script.Path, timeout)
+	res := RunScript(probeScript(script, target, timeout))
+	duration := time.Since(start)
+
+	success := 0.0
+	var metrics []lib.Metric
+	if res.Error == nil {
+		success = 1.0
+		metrics = res.Metrics
+	} else if res.Stderr != "" {
+		log.Warnf("Probe of target %s with script %s failed (Error: %v, Stderr: %s)", target, script.Name, res.Error, outputSnippet(res.Stderr))
+	} else {
+		log.Warnf("Probe of target %s with script %s failed (Error: %v)", target, script.Name, res.Error)
+	}
+
+	d.mu.RLock()
+	series := lib.GenerateSeries(metrics)
+	d.mu.RUnlock()
+	// The probe series aren't prefixed, so they can be used like the ones of blackbox_exporter
+	series += "# TYPE probe_success gauge\n"
+	series += "# HELP probe_success indicates whether the probe was successful\n"
+	series += fmt.Sprintf("probe_success %s\n", strconv.FormatFloat(success, 'f', -1, 64))
+	series += "# TYPE probe_duration_seconds gauge\n"
+	series += "# HELP probe_duration_seconds indicates the number of seconds the probe has taken\n"
+	series += fmt.Sprintf("probe_duration_seconds %s\n", strconv.FormatFloat(duration.Seconds(), 'f', -1, 64))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, series)
+}
+
+// probeTimeout returns the timeout of the probe, which is the timeout of the script limited
+// to the scrape timeout of Prometheus, less scrapeTimeoutOffset, when it's sent
+func probeTimeout(script config.Script, scrapeTimeout string) (time.Duration, error) {
+	timeout, _ := time.ParseDuration(script.Timeout)
+	if scrapeTimeout == "" {
+		return timeout, nil
+	}
+
+	seconds, err := strconv.ParseFloat(scrapeTimeout, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid X-Prometheus-Scrape-Timeout-Seconds header '%s'", scrapeTimeout)
+	}
+	limit := time.Duration(seconds * float64(time.Second))
+	if limit > scrapeTimeoutOffset {
+		limit -= scrapeTimeoutOffset
+	}
+	if limit < timeout {
+		return limit, nil
+	}
+	return timeout, nil
+}
+
+// probeScript returns the script to execute for the probe of the target, with the target
+// in its args and the timeout of the probe
+func probeScript(script config.Script, target string, timeout time.Duration) config.Script {
+	script.Args = targetArgs(script.Args, target)
+	script.Timeout = timeout.String()
+	return script
+}
@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestProbeTimeout(t *testing.T) {
+	tests := []struct {
+		name          string
+		timeout       string
+		scrapeTimeout string
+		want          time.Duration
+		wantErr       bool
+	}{
+		{name: "no scrape timeout", timeout: "10s", want: 10 * time.Second},
+		{name: "shorter scrape timeout", timeout: "10s", scrapeTimeout: "5", want: 4500 * time.Millisecond},
+		{name: "longer scrape timeout", timeout: "10s", scrapeTimeout: "30", want: 10 * time.Second},
+		{name: "scrape timeout under the offset", timeout: "10s", scrapeTimeout: "0.2", want: 200 * time.Millisecond},
+		{name: "invalid scrape timeout", timeout: "10s", scrapeTimeout: "soon", wantErr: true},
+		{name: "negative scrape timeout", timeout: "10s", scrapeTimeout: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeTimeout(config.Script{Timeout: tt.timeout}, tt.scrapeTimeout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("probeTimeout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("probeTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleProbe(t *testing.T) {
+	scripts := []config.Script{
+		{Name: "check_echo", Path: "exit", Args: []string{"{{target}}"}, OutputType: "exit_code", Timeout: "5s", Probe: true},
+		{Name: "check_scheduled", Path: "true", OutputType: "exit_code", Timeout: "5s"},
+		{Name: "check_db", Path: "exit", Args: []string{"{{target}}"}, OutputType: "exit_code", Timeout: "5s", Probe: true, ConcurrencyGroup: "db"},
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		busySlots  int
+		busyGroup  bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "probe", query: "check=check_echo&target=2", wantStatus: http.StatusOK, wantBody: "probe_success 1"},
+		{name: "missing check", query: "target=2", wantStatus: http.StatusBadRequest},
+		{name: "missing target", query: "check=check_echo", wantStatus: http.StatusBadRequest},
+		{name: "invalid target", query: "check=check_echo&target=-v", wantStatus: http.StatusBadRequest},
+		{name: "not a probe", query: "check=check_scheduled&target=2", wantStatus: http.StatusBadRequest},
+		{name: "slot left", query: "check=check_echo&target=2", busySlots: 1, wantStatus: http.StatusOK},
+		{name: "too many probes", query: "check=check_echo&target=2", busySlots: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "concurrency group slot left", query: "check=check_db&target=2", wantStatus: http.StatusOK, wantBody: "probe_success 1"},
+		{name: "concurrency group busy", query: "check=check_db&target=2", busyGroup: true, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &daemon{cnf: &config.Config{Scripts: scripts}, groups: newGroupLimiter(map[string]int{"db": 1}), probeSlots: make(chan struct{}, 2)}
+			for i := 0; i < tt.busySlots; i++ {
+				d.probeSlots <- struct{}{}
+			}
+			if tt.busyGroup {
+				d.groups.acquire(context.Background(), "db")
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/probe?"+tt.query, nil)
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.7")
+			d.handleProbe(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+			if len(d.probeSlots) != tt.busySlots {
+				t.Errorf("%d probe slots in use after the probe, want %d", len(d.probeSlots), tt.busySlots)
+			}
+			wantRunning := 0
+			if tt.busyGroup {
+				wantRunning = 1
+			}
+			if d.groups.running["db"] != wantRunning {
+				t.Errorf("%d slots of the db group in use after the probe, want %d", d.groups.running["db"], wantRunning)
+			}
+		})
+	}
+}
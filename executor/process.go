@@ -0,0 +1,88 @@
+package executor
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// runProcessTree starts the command and waits for it to complete.  When the timeout
+// expires first, the process group of the script is sent SIGTERM, then SIGKILL along
+// with every process left in its cgroup if it is still running after the kill grace
+// period of the script.  The command is always waited for so that it gets reaped, and
+// the processes it left running in its process group are killed.
+//
+// exec.CommandContext isn't used as it only kills the direct child of the executor.
+// See: https://github.com/golang/go/issues/22485
+func runProcessTree(cmd *exec.Cmd, script config.Script, timeout time.Duration, cg *scriptCgroup) (bool, error) {
+	grace, _ := time.ParseDuration(script.KillGrace)
+
+	// Processes which were started by the script and hold on to its output would
+	// otherwise keep Wait from returning after the script exits
+	cmd.WaitDelay = grace
+
+	setup, err := startWithLimits(cmd, script)
+	if err != nil {
+		return false, err
+	}
+	defer setup.close()
+	pgid := cmd.Process.Pid
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if errors.Is(err, exec.ErrWaitDelay) {
+			log.Warnf("Output of script %s was still held open %v after it exited", script.Path, grace)
+			err = nil
+		}
+		killProcessGroup(pgid)
+		if setupErr := setup.err(); setupErr != nil {
+			return false, setupErr
+		}
+		return false, err
+	case <-timer.C:
+	}
+
+	log.Warnf("timeout of %v has passed for script %s (output type: %s). Terminating script",
+		timeout,
+		script.Path,
+		script.OutputType)
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		log.Warnf("Error terminating running script %s (output type: %s): %s", script.Path, script.OutputType, err)
+	}
+
+	select {
+	case err := <-done:
+		killProcessGroup(pgid)
+		return true, err
+	case <-time.After(grace):
+	}
+
+	log.Warnf("Script %s (output type: %s) still running %v after SIGTERM. Killing script",
+		script.Path,
+		script.OutputType,
+		grace)
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Warnf("Error killing running script %s (output type: %s): %s", script.Path, script.OutputType, err)
+	}
+	cg.kill()
+	return true, <-done
+}
+
+// killProcessGroup kills the processes left in the process group of a script which exited
+func killProcessGroup(pgid int) {
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Warnf("Error killing processes left by script (process group %d): %s", pgid, err)
+	}
+}
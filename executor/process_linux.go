@@ -0,0 +1,10 @@
+package executor
+
+import (
+	"syscall"
+)
+
+// setDeathSignal makes the kernel kill the script if the executor dies
+func setDeathSignal(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
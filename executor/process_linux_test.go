@@ -0,0 +1,130 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// processGone returns true once the process no longer exists or is a zombie, waiting
+// for it to be reaped for up to a second
+func processGone(pid int) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return true
+		}
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return true
+		}
+		// The state follows the command name, which is in parentheses
+		if fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:])); len(fields) > 0 && fields[0] == "Z" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunProcessTreeTermination(t *testing.T) {
+	const (
+		timeout = 500 * time.Millisecond
+		grace   = time.Second
+	)
+
+	tests := []struct {
+		name    string
+		command string
+		// timeout defaults to the one of the test
+		timeout time.Duration
+		// wantMin and wantMax bound the time it takes to execute the script
+		wantMin    time.Duration
+		wantMax    time.Duration
+		wantReason string
+		wantStderr string
+	}{
+		{
+			name:       "exits on SIGTERM",
+			command:    `trap 'echo terminating >&2; exit 3' TERM; sleep 30 & echo $! > "$CHILD"; wait`,
+			wantMin:    timeout,
+			wantMax:    timeout + grace/2,
+			wantReason: ReasonTimeout,
+			wantStderr: "terminating",
+		},
+		{
+			name:       "ignores SIGTERM",
+			command:    `trap '' TERM; echo started >&2; sleep 30 & echo $! > "$CHILD"; while :; do wait; done`,
+			wantMin:    timeout + grace,
+			wantMax:    timeout + grace + time.Second,
+			wantReason: ReasonTimeout,
+			wantStderr: "started",
+		},
+		{
+			name:    "leaves a child holding its output",
+			command: `sleep 30 & echo $! > "$CHILD"; echo 1`,
+			timeout: 5 * time.Second,
+			wantMin: grace,
+			wantMax: grace + time.Second,
+		},
+		{
+			name:    "leaves a child in the background",
+			command: `sleep 30 >/dev/null 2>&1 & echo $! > "$CHILD"; echo 1`,
+			wantMax: grace / 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child := filepath.Join(t.TempDir(), "child")
+			script := config.Script{
+				Path:           "eval",
+				Args:           []string{"CHILD=" + child + "; " + tt.command},
+				OutputType:     "stdout",
+				KillGrace:      grace.String(),
+				MaxOutputBytes: 1024,
+			}
+
+			scriptTimeout := timeout
+			if tt.timeout > 0 {
+				scriptTimeout = tt.timeout
+			}
+			start := time.Now()
+			res := runScriptAttempt(script, scriptTimeout)
+			elapsed := time.Since(start)
+
+			if elapsed < tt.wantMin || elapsed > tt.wantMax {
+				t.Errorf("execution took %v, want between %v and %v", elapsed, tt.wantMin, tt.wantMax)
+			}
+			if tt.wantReason == "" && res.Error != nil {
+				t.Errorf("unexpected error: %v", res.Error)
+			}
+			var execErr *ExecutionError
+			if tt.wantReason != "" && (!errors.As(res.Error, &execErr) || execErr.Reason != tt.wantReason) {
+				t.Errorf("error = %v, want reason %s", res.Error, tt.wantReason)
+			}
+			if !strings.Contains(res.Stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", res.Stderr, tt.wantStderr)
+			}
+
+			content, err := os.ReadFile(child)
+			if err != nil {
+				t.Fatalf("could not read the pid of the child: %v", err)
+			}
+			pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+			if err != nil {
+				t.Fatalf("invalid pid of the child %q", content)
+			}
+			if !processGone(pid) {
+				syscall.Kill(pid, syscall.SIGKILL)
+				t.Errorf("child process %d is still running", pid)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"syscall"
+)
+
+// setDeathSignal is only supported on Linux
+func setDeathSignal(attr *syscall.SysProcAttr) {}
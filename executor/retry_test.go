@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestRunScriptRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		delay   string
+		backoff float64
+		timeout string
+		// succeedOn is the invocation the script succeeds on, it always fails when it's 0
+		succeedOn    int
+		wantAttempts int
+		wantSuccess  bool
+	}{
+		{name: "success without retries", delay: "10ms", backoff: 1, timeout: "5s", succeedOn: 1, wantAttempts: 1, wantSuccess: true},
+		{name: "failure without retries", delay: "10ms", backoff: 1, timeout: "5s", wantAttempts: 1},
+		{name: "success on the first attempt", retries: 2, delay: "10ms", backoff: 1, timeout: "5s", succeedOn: 1, wantAttempts: 1, wantSuccess: true},
+		{name: "success on a retry", retries: 2, delay: "10ms", backoff: 1, timeout: "5s", succeedOn: 2, wantAttempts: 2, wantSuccess: true},
+		{name: "every attempt fails", retries: 2, delay: "10ms", backoff: 2, timeout: "5s", wantAttempts: 3},
+		// The second retry would be delayed by 900ms, while only about 700ms are left
+		{name: "backoff past the timeout", retries: 5, delay: "300ms", backoff: 3, timeout: "1s", wantAttempts: 2},
+		{name: "delay past the timeout", retries: 3, delay: "2s", backoff: 1, timeout: "1s", wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invocations := filepath.Join(t.TempDir(), "invocations")
+			succeedOn := tt.succeedOn
+			if succeedOn == 0 {
+				succeedOn = 1000
+			}
+			script := config.Script{
+				Name:           "flaky",
+				Path:           "eval",
+				Args:           []string{fmt.Sprintf(`echo x >> %s; if [ "$(wc -l < %s)" -ge %d ]; then echo 1; else exit 2; fi`, invocations, invocations, succeedOn)},
+				OutputType:     "stdout",
+				Timeout:        tt.timeout,
+				Retries:        tt.retries,
+				RetryDelay:     tt.delay,
+				RetryBackoff:   tt.backoff,
+				MaxOutputBytes: 1024,
+			}
+
+			results := newResultStore()
+			series := execute(&config.Config{MaxConcurrency: 1, Scripts: []config.Script{script}}, []config.Script{script}, results, newGroupLimiter(nil), 0)
+
+			content, err := os.ReadFile(invocations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(string(content), "x"); n != tt.wantAttempts {
+				t.Errorf("script was invoked %d times, want %d", n, tt.wantAttempts)
+			}
+			run, ok := results.lastRun(script.Path, "")
+			if !ok {
+				t.Fatal("no result recorded for the script")
+			}
+			if run.Result.Attempts != tt.wantAttempts {
+				t.Errorf("Attempts = %d, want %d", run.Result.Attempts, tt.wantAttempts)
+			}
+			if success := run.Result.Error == nil; success != tt.wantSuccess {
+				t.Errorf("success = %v, want %v (error: %v)", success, tt.wantSuccess, run.Result.Error)
+			}
+			timeout, _ := time.ParseDuration(tt.timeout)
+			if elapsed := time.Duration(run.Result.TotalExecTime) * time.Millisecond; elapsed > timeout {
+				t.Errorf("retries took %v, past the timeout of %v", elapsed, timeout)
+			}
+
+			found := false
+			for _, metric := range series {
+				if metric.Name == "script_last_attempts" {
+					found = true
+					if metric.Value != float64(tt.wantAttempts) {
+						t.Errorf("script_last_attempts = %v, want %d", metric.Value, tt.wantAttempts)
+					}
+				}
+			}
+			if !found {
+				t.Error("no script_last_attempts series")
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !darwin
+// +build !darwin
+
+package executor
+
+// maxRSSUnit is the unit of the maximum resident set size reported in rusage, which is
+// kilobytes on Linux and the BSDs
+const maxRSSUnit = 1024
@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"strconv"
+)
+
+// SandboxOptions describes the environment set up by the sandbox-exec sub-command in the
+// namespaces of a script before the script is executed
+type SandboxOptions struct {
+	Loopback      bool
+	ReadOnlyRoot  bool
+	WritablePaths []string
+	PrivateTmp    bool
+	MountProc     bool
+	Init          bool
+	UID           int
+	GID           int
+	Groups        []int
+}
+
+// args returns the flags of the sandbox-exec sub-command matching the options
+func (o SandboxOptions) args() []string {
+	args := []string{}
+	if o.Loopback {
+		args = append(args, "--loopback")
+	}
+	if o.ReadOnlyRoot {
+		args = append(args, "--read-only-root")
+	}
+	for _, p := range o.WritablePaths {
+		args = append(args, "--writable", p)
+	}
+	if o.PrivateTmp {
+		args = append(args, "--private-tmp")
+	}
+	if o.MountProc {
+		args = append(args, "--mount-proc")
+	}
+	if o.Init {
+		args = append(args, "--init")
+	}
+	if o.UID != -1 {
+		args = append(args, "--uid", strconv.Itoa(o.UID), "--gid", strconv.Itoa(o.GID))
+		for _, g := range o.Groups {
+			args = append(args, "--group", strconv.Itoa(g))
+		}
+	}
+	return args
+}
@@ -0,0 +1,300 @@
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// applySandbox starts the command in the namespaces configured for the script, through
+// the sandbox-exec sub-command of the executor which sets up the namespaces before
+// executing the script.  The sub-command needs to be privileged to set up the mounts,
+// so it also takes over switching to the user of the script.
+func applySandbox(cmd *exec.Cmd, script config.Script) error {
+	sandbox := script.Sandbox
+	if !sandbox.Enabled() {
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find the executor binary: %v", err)
+	}
+
+	opts := SandboxOptions{
+		Loopback:      sandbox.NoNetwork,
+		ReadOnlyRoot:  sandbox.ReadOnlyRoot,
+		WritablePaths: sandbox.WritablePaths,
+		PrivateTmp:    sandbox.PrivateTmp,
+		MountProc:     sandbox.PIDNamespace,
+		Init:          sandbox.PIDNamespace,
+		UID:           -1,
+		GID:           -1,
+	}
+	if cred := cmd.SysProcAttr.Credential; cred != nil {
+		opts.UID, opts.GID = int(cred.Uid), int(cred.Gid)
+		for _, g := range cred.Groups {
+			opts.Groups = append(opts.Groups, int(g))
+		}
+		cmd.SysProcAttr.Credential = nil
+	}
+
+	if sandbox.NoNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if sandbox.ReadOnlyRoot || sandbox.PrivateTmp || sandbox.PIDNamespace {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	if sandbox.PIDNamespace {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWPID
+	}
+
+	args := append([]string{self, "sandbox-exec"}, opts.args()...)
+	args = append(args, "--")
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// SandboxExec sets up the namespaces the process was started in and executes the
+// command.  It only returns if the setup or the execution failed.
+func SandboxExec(opts SandboxOptions, argv []string) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("no command to execute")
+	}
+
+	if opts.Loopback {
+		if err := setLoopbackUp(); err != nil {
+			return fmt.Errorf("could not set up the loopback interface: %v", err)
+		}
+	}
+
+	if opts.ReadOnlyRoot || opts.PrivateTmp || opts.MountProc {
+		// Keep the mounts below from propagating to the host
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("could not make the mounts private: %v", err)
+		}
+	}
+	if opts.ReadOnlyRoot {
+		if err := remountReadOnly(); err != nil {
+			return err
+		}
+		for _, p := range opts.WritablePaths {
+			if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+				return fmt.Errorf("could not bind mount %s: %v", p, err)
+			}
+			// The bind mount inherits the read-only flag from the mount it comes from
+			if err := syscall.Mount("", p, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+				return fmt.Errorf("could not make %s writable: %v", p, err)
+			}
+		}
+	}
+	if opts.PrivateTmp {
+		if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("could not mount a private /tmp: %v", err)
+		}
+	}
+	if opts.MountProc {
+		if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+			return fmt.Errorf("could not mount /proc: %v", err)
+		}
+	}
+
+	if opts.Init {
+		return runInit(opts, argv)
+	}
+
+	if opts.UID != -1 {
+		if err := syscall.Setgroups(opts.Groups); err != nil {
+			return fmt.Errorf("could not set the supplementary groups: %v", err)
+		}
+		if err := syscall.Setgid(opts.GID); err != nil {
+			return fmt.Errorf("could not set the group: %v", err)
+		}
+		if err := syscall.Setuid(opts.UID); err != nil {
+			return fmt.Errorf("could not set the user: %v", err)
+		}
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	// The parent death signal is per thread and cleared when changing the user, so it
+	// is set again on the thread which executes the command
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_PDEATHSIG, uintptr(syscall.SIGKILL), 0); errno != 0 {
+		return fmt.Errorf("could not set the parent death signal: %v", errno)
+	}
+	return syscall.Exec(path, argv, os.Environ())
+}
+
+// initForwardedSignals are the signals the init process forwards to the command
+var initForwardedSignals = []os.Signal{
+	syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2,
+}
+
+// runInit executes the command as a child in its own process group and stays the init
+// process of the PID namespace, as the init process ignores the signals it has no handler
+// for, like SIGTERM.  The signals it receives are forwarded to the process group of the
+// command and the orphaned processes are reaped.  The init process can't be terminated by
+// its own signals either, so a command terminated by a signal is reported as having
+// exited with 128 plus the signal number.  It only returns if the command couldn't be
+// started.
+func runInit(opts SandboxOptions, argv []string) error {
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, append(initForwardedSignals, syscall.SIGCHLD)...)
+
+	sys := &syscall.SysProcAttr{Setpgid: true}
+	if opts.UID != -1 {
+		groups := make([]uint32, len(opts.Groups))
+		for i, g := range opts.Groups {
+			groups[i] = uint32(g)
+		}
+		sys.Credential = &syscall.Credential{Uid: uint32(opts.UID), Gid: uint32(opts.GID), Groups: groups}
+	}
+	files := []uintptr{0, 1, 2}
+	// The pipe reporting the errors setting the resource limits is passed on
+	if flags, _, errno := syscall.RawSyscall(syscall.SYS_FCNTL, uintptr(setupErrorsFd), syscall.F_GETFD, 0); errno == 0 && flags&syscall.FD_CLOEXEC == 0 {
+		files = append(files, uintptr(setupErrorsFd))
+	}
+	pid, err := syscall.ForkExec(path, argv, &syscall.ProcAttr{Env: os.Environ(), Files: files, Sys: sys})
+	if err != nil {
+		return err
+	}
+
+	for sig := range signals {
+		if sig != syscall.SIGCHLD {
+			syscall.Kill(-pid, sig.(syscall.Signal))
+			continue
+		}
+		for {
+			var status syscall.WaitStatus
+			wpid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+			if err != nil || wpid <= 0 {
+				break
+			}
+			if wpid != pid {
+				continue
+			}
+			// Exiting kills the processes left in the namespace
+			if status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
+			os.Exit(status.ExitStatus())
+		}
+	}
+	return nil
+}
+
+// waitStatus returns the wait status of the script.  The init process executing the
+// scripts in a PID namespace reports a script terminated by a signal as having exited
+// with 128 plus the signal number, which is turned back into the signal.
+func waitStatus(script config.Script, state *os.ProcessState) (syscall.WaitStatus, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if ok && script.Sandbox.PIDNamespace && status.Exited() && status.ExitStatus() > 128 && status.ExitStatus() <= 128+64 {
+		return syscall.WaitStatus(status.ExitStatus() - 128), true
+	}
+	return status, ok
+}
+
+// setLoopbackUp brings up the loopback interface, which is down in a new network namespace
+func setLoopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	ifr.flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// remountReadOnly remounts every mount of the namespace as read-only, keeping the
+// other flags of each mount
+func remountReadOnly() error {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mountFlags := map[string]uintptr{
+		"nosuid":     syscall.MS_NOSUID,
+		"nodev":      syscall.MS_NODEV,
+		"noexec":     syscall.MS_NOEXEC,
+		"noatime":    syscall.MS_NOATIME,
+		"nodiratime": syscall.MS_NODIRATIME,
+		"relatime":   syscall.MS_RELATIME,
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// See proc(5) for the format of the mountinfo fields
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[4])
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		for _, opt := range strings.Split(fields[5], ",") {
+			flags |= mountFlags[opt]
+		}
+		if err := syscall.Mount("", mountPoint, "", flags, ""); err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("could not remount %s as read-only: %v", mountPoint, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes of the spaces, tabs, newlines and
+// backslashes in the paths of /proc/self/mountinfo
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			var c byte
+			valid := true
+			for _, d := range path[i+1 : i+4] {
+				if d < '0' || d > '7' {
+					valid = false
+					break
+				}
+				c = c*8 + byte(d-'0')
+			}
+			if valid {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestWaitStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      string
+		pidNamespace bool
+		wantSignal   syscall.Signal
+		wantExit     int
+	}{
+		{name: "exit code", command: "exit 2", wantExit: 2},
+		{name: "signal", command: "kill -TERM $$", wantSignal: syscall.SIGTERM, wantExit: -1},
+		{name: "high exit code", command: "exit 143", wantExit: 143},
+		{name: "exit code in PID namespace", command: "exit 2", pidNamespace: true, wantExit: 2},
+		{name: "signal reported by init", command: "exit 143", pidNamespace: true, wantSignal: syscall.SIGTERM, wantExit: -1},
+		{name: "signal in PID namespace", command: "kill -KILL $$", pidNamespace: true, wantSignal: syscall.SIGKILL, wantExit: -1},
+		{name: "exit code above the signals", command: "exit 250", pidNamespace: true, wantExit: 250},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command("/bin/bash", "-c", tt.command)
+			cmd.Run()
+			script := config.Script{Sandbox: config.Sandbox{PIDNamespace: tt.pidNamespace}}
+
+			status, ok := waitStatus(script, cmd.ProcessState)
+			if !ok {
+				t.Fatal("waitStatus() returned no status")
+			}
+			if status.Signaled() != (tt.wantSignal != 0) || (status.Signaled() && status.Signal() != tt.wantSignal) {
+				t.Errorf("signal = %v (signaled %v), want %v", status.Signal(), status.Signaled(), tt.wantSignal)
+			}
+			if status.ExitStatus() != tt.wantExit {
+				t.Errorf("exit status = %d, want %d", status.ExitStatus(), tt.wantExit)
+			}
+		})
+	}
+}
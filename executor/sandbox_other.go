@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// applySandbox returns an error when a sandbox is configured, as namespaces are only
+// supported on Linux
+func applySandbox(cmd *exec.Cmd, script config.Script) error {
+	if script.Sandbox.Enabled() {
+		return errors.New("sandboxing is only supported on Linux")
+	}
+	return nil
+}
+
+// SandboxExec is only supported on Linux
+func SandboxExec(opts SandboxOptions, argv []string) error {
+	return errors.New("sandboxing is only supported on Linux")
+}
+
+// waitStatus returns the wait status of the script
+func waitStatus(script config.Script, state *os.ProcessState) (syscall.WaitStatus, bool) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return status, ok
+}
@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// scheduler selects the scripts to execute at each run, according to their check period
+// and, in daemon mode, their cron schedule.  The series of the last execution of the
+// scripts which aren't executed are reported again in daemon mode.
+type scheduler struct {
+	daemon    bool
+	periods   map[string]config.TimePeriod
+	ranges    map[string]*lib.TimeRanges
+	schedules map[string]*lib.CronSchedule
+	next      map[string]time.Time
+	last      map[string][]lib.Metric
+}
+
+func newScheduler(cnf *config.Config, daemon bool) *scheduler {
+	s := &scheduler{
+		daemon:    daemon,
+		periods:   cnf.TimePeriods,
+		ranges:    map[string]*lib.TimeRanges{},
+		schedules: map[string]*lib.CronSchedule{},
+		next:      map[string]time.Time{},
+		last:      map[string][]lib.Metric{},
+	}
+	// The config has already been validated
+	for name, period := range cnf.TimePeriods {
+		s.ranges[name], _ = lib.ParseTimeRanges(period.Ranges)
+	}
+	now := time.Now()
+	for _, script := range cnf.Scripts {
+		if script.Schedule == "" || !daemon {
+			continue
+		}
+		schedule, _ := lib.ParseCronSchedule(script.Schedule)
+		s.schedules[script.Path] = schedule
+		s.next[script.Path] = schedule.Next(now)
+	}
+	return s
+}
+
+// reload returns the scheduler for the reloaded config, keeping the next scheduled time
+// and the last series of the scripts which are unchanged
+func (s *scheduler) reload(cnf *config.Config, unchanged map[string]bool) *scheduler {
+	reloaded := newScheduler(cnf, s.daemon)
+	for scriptPath := range unchanged {
+		if next, ok := s.next[scriptPath]; ok {
+			reloaded.next[scriptPath] = next
+		}
+		if last, ok := s.last[scriptPath]; ok {
+			reloaded.last[scriptPath] = last
+		}
+	}
+	return reloaded
+}
+
+// inPeriod returns true when t is within the time period and none of its exclusions
+func (s *scheduler) inPeriod(name string, t time.Time) bool {
+	if !s.ranges[name].Contains(t) {
+		return false
+	}
+	for _, excluded := range s.periods[name].Exclude {
+		if s.inPeriod(excluded, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// due returns the scripts to execute at t: the ones within their check period and, when
+// they have a schedule, for which the next scheduled time has passed.  Probe scripts are
+// only executed through the /probe endpoint.
+func (s *scheduler) due(scripts []config.Script, t time.Time) []config.Script {
+	due := []config.Script{}
+	for _, script := range scripts {
+		if script.Probe {
+			continue
+		}
+		if script.CheckPeriod != "" && !s.inPeriod(script.CheckPeriod, t) {
+			log.Debugf("Script %s is outside of its check period %s", script.Path, script.CheckPeriod)
+			continue
+		}
+		if schedule, ok := s.schedules[script.Path]; ok {
+			if t.Before(s.next[script.Path]) {
+				continue
+			}
+			s.next[script.Path] = schedule.Next(t)
+			log.Debugf("Script %s is scheduled, next execution at %v", script.Path, s.next[script.Path])
+		}
+		due = append(due, script)
+	}
+	return due
+}
+
+// complete returns the series of the run at t, along with the series of the last execution
+// of the scripts which weren't executed in daemon mode, marked as carried, and the active
+// period series of the scripts which have a check period
+func (s *scheduler) complete(scripts []config.Script, due []config.Script, series []lib.Metric, t time.Time) []lib.Metric {
+	if s.daemon {
+		executed := map[string][]lib.Metric{}
+		for _, script := range due {
+			executed[script.Path] = []lib.Metric{}
+		}
+		for _, metric := range series {
+			if _, ok := executed[metric.Source]; ok {
+				executed[metric.Source] = append(executed[metric.Source], metric)
+			}
+		}
+		for _, script := range scripts {
+			if metrics, ok := executed[script.Path]; ok {
+				s.last[script.Path] = metrics
+			} else {
+				for _, metric := range s.last[script.Path] {
+					metric.Carried = true
+					series = append(series, metric)
+				}
+			}
+		}
+	}
+
+	for _, script := range scripts {
+		if script.CheckPeriod == "" {
+			continue
+		}
+		active := 0.0
+		if s.inPeriod(script.CheckPeriod, t) {
+			active = 1.0
+		}
+		series = append(series, lib.Metric{
+			Name: "script_in_active_period",
+			Labels: map[string]string{
+				"script": script.Path,
+				"period": script.CheckPeriod,
+			},
+			Value:  active,
+			Type:   "gauge",
+			Help:   "indicates when a script is within its check period",
+			Source: script.Path,
+		})
+	}
+	return series
+}
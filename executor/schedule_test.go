@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func TestSchedulerCompleteCarriesSeries(t *testing.T) {
+	scheduled := config.Script{Path: "/plugins/check_backup", Schedule: "0 3 * * *"}
+	every := config.Script{Path: "/plugins/check_load"}
+	scripts := []config.Script{scheduled, every}
+	runSeries := func(values ...float64) []lib.Metric {
+		series := []lib.Metric{}
+		for i, script := range scripts[len(scripts)-len(values):] {
+			series = append(series, lib.Metric{
+				Name:   "script_last_execution_time_ms",
+				Labels: map[string]string{"script": script.Path},
+				Value:  values[i],
+				Source: script.Path,
+			})
+		}
+		return series
+	}
+
+	tests := []struct {
+		name   string
+		daemon bool
+		// wantCarried are the values of the carried series of the second run
+		wantCarried []float64
+	}{
+		{name: "daemon mode", daemon: true, wantCarried: []float64{120}},
+		{name: "single execution", daemon: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(&config.Config{Scripts: scripts}, tt.daemon)
+			now := time.Now()
+
+			// Both scripts are executed by the first run, as the scheduled one is due
+			s.next[scheduled.Path] = now.Add(-time.Minute)
+			due := s.due(scripts, now)
+			if len(due) != 2 {
+				t.Fatalf("expected both scripts to be due, got %d", len(due))
+			}
+			for _, metric := range s.complete(scripts, due, runSeries(120, 30), now) {
+				if metric.Carried {
+					t.Errorf("series %s of the first run is carried", metric.Source)
+				}
+			}
+
+			// The scheduled script isn't due in the second run
+			later := now.Add(time.Minute)
+			due = s.due(scripts, later)
+			if tt.daemon && (len(due) != 1 || due[0].Path != every.Path) {
+				t.Fatalf("expected only %s to be due, got %+v", every.Path, due)
+			}
+			carried := []float64{}
+			for _, metric := range s.complete(scripts, due, runSeries(40), later) {
+				if metric.Carried {
+					if metric.Source != scheduled.Path {
+						t.Errorf("series of the executed script %s is carried", metric.Source)
+					}
+					carried = append(carried, metric.Value)
+				}
+			}
+			if len(carried) != len(tt.wantCarried) || (len(carried) > 0 && carried[0] != tt.wantCarried[0]) {
+				t.Errorf("expected the carried values %v, got %v", tt.wantCarried, carried)
+			}
+			// The series kept for the next runs aren't marked as carried
+			for _, metric := range s.last[scheduled.Path] {
+				if metric.Carried {
+					t.Errorf("the series kept for %s are marked as carried", scheduled.Path)
+				}
+			}
+		})
+	}
+}
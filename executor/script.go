@@ -1,14 +1,12 @@
 package executor
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -34,6 +32,64 @@ type ExecutionResult struct {
 	Metrics       []lib.Metric
 	Error         error
 	TotalExecTime int64
+	QueueWaitTime int64
+	Attempts      int
+	Cgroup        *CgroupStats
+	Usage         *ResourceUsage
+	// Stdout and Stderr hold the output of the script, up to its max_output_bytes
+	Stdout          string
+	Stderr          string
+	OutputTruncated bool
+	// Skipped is set when the script wasn't executed as a script it depends on didn't succeed
+	Skipped bool
+	// Target is the target the script was executed against, when it has targets
+	Target string
+}
+
+// CgroupStats holds the resource usage of a script execution accounted by its cgroup
+type CgroupStats struct {
+	MemoryPeakBytes     float64
+	CPUUserSeconds      float64
+	CPUSystemSeconds    float64
+	CPUThrottledSeconds float64
+}
+
+// ResourceUsage holds the resource usage of a script execution reported by the kernel
+// when the script process was reaped
+type ResourceUsage struct {
+	CPUUserSeconds         float64
+	CPUSystemSeconds       float64
+	MaxRSSBytes            float64
+	VoluntaryCtxSwitches   float64
+	InvoluntaryCtxSwitches float64
+	BlockInputOps          float64
+	BlockOutputOps         float64
+	// Signal is the signal which terminated the script, 0 when it exited
+	Signal int
+}
+
+// resourceUsage returns the resource usage of the reaped script process
+func resourceUsage(script config.Script, state *os.ProcessState) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+	usage := &ResourceUsage{
+		CPUUserSeconds:         state.UserTime().Seconds(),
+		CPUSystemSeconds:       state.SystemTime().Seconds(),
+		MaxRSSBytes:            float64(rusage.Maxrss) * maxRSSUnit,
+		VoluntaryCtxSwitches:   float64(rusage.Nvcsw),
+		InvoluntaryCtxSwitches: float64(rusage.Nivcsw),
+		BlockInputOps:          float64(rusage.Inblock),
+		BlockOutputOps:         float64(rusage.Oublock),
+	}
+	if waitStatus, ok := waitStatus(script, state); ok && waitStatus.Signaled() {
+		usage.Signal = int(waitStatus.Signal())
+	}
+	return usage
 }
 
 // GetScripts returns the list of scripts in the provided directory when in simple mode
@@ -49,94 +105,180 @@ func GetScripts(scriptsDir string) ([]string, error) {
 		return nil
 	})
 	if err != nil {
-		log.Error("Could not get scripts in directory: %v", err.Error())
+		log.Errorf("Could not get scripts in directory: %v", err.Error())
 		return []string{}, err
 	}
 	log.Debug("Done finding scripts")
 	return files, nil
 }
 
-// RunScript starts the execution of the script
+// RunScript starts the execution of the script. Failed attempts are retried according
+// to the retry policy of the script, as long as the overall timeout of the script allows it.
+// The state series derived from the thresholds of the script are added to its metrics.
 func RunScript(script config.Script) ExecutionResult {
 
-	if len(script.Labels) == 0 {
-		script.Labels = map[string]string{}
+	// The labels are copied as the map is shared with the config
+	labels := make(map[string]string, len(script.Labels)+1)
+	for k, v := range script.Labels {
+		labels[k] = v
 	}
-	script.Labels["script"] = script.Path
+	labels["script"] = script.Path
+	script.Labels = labels
 
 	timeout, _ := time.ParseDuration(script.Timeout)
+	delay, _ := time.ParseDuration(script.RetryDelay)
 
 	execStart := time.Now()
+	deadline := execStart.Add(timeout)
 
-	if script.OutputType == "exit_code" {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+	var result ExecutionResult
+	for attempt := 1; ; attempt++ {
+		result = runScriptAttempt(script, time.Until(deadline))
+		result.Attempts = attempt
+		if result.Error == nil || attempt > script.Retries {
+			break
+		}
+		if time.Until(deadline) <= delay {
+			log.Warnf("Attempt %d of script %s failed (Error: %v), not enough time left before the timeout to retry",
+				attempt,
+				script.Path,
+				result.Error)
+			break
+		}
+		log.Warnf("Attempt %d of script %s failed (Error: %v), retrying in %v", attempt, script.Path, result.Error, delay)
+		time.Sleep(delay)
+		delay = time.Duration(float64(delay) * script.RetryBackoff)
+	}
 
-		log.Trace("Running script %s (output type: %s)", script.Path, script.OutputType)
-		cmd := exec.CommandContext(ctx, "/bin/bash", "-c", script.Path)
-		var waitStatus syscall.WaitStatus
-		execErr := cmd.Run()
+	if result.Error == nil {
+		result.Metrics = append(result.Metrics, stateSeries(script, result.Metrics)...)
+	}
+	result.ScriptPath = script.Path
+	result.Target = script.Target
+	result.TotalExecTime = time.Since(execStart).Milliseconds()
+	return result
+}
 
-		duration := time.Since(execStart)
-		execTotalMs := duration.Milliseconds()
+// runScriptAttempt runs a single attempt of the script execution, in its own cgroup
+// when cgroups are configured
+func runScriptAttempt(script config.Script, timeout time.Duration) ExecutionResult {
 
-		if ctx.Err() == context.DeadlineExceeded {
-			return ExecutionResult{
-				ScriptPath:    script.Path,
-				Error:         errors.New("Script execution deadline exceeded"),
-				TotalExecTime: execTotalMs,
-			}
+	cg, err := newScriptCgroup(script)
+	if err != nil {
+		return ExecutionResult{
+			ScriptPath: script.Path,
+			Error:      fmt.Errorf("Could not set up cgroup: %v", err),
 		}
-		if execErr != nil {
-			re := regexp.MustCompile("exit status ([0-9])+")
-			match := re.FindStringSubmatch(execErr.Error())
-			if len(match) >= 1 {
-				log.Debugf("Success running script %s (output type: %s)", script.Path, script.OutputType)
-				i, _ := strconv.Atoi(match[1])
-				return ExecutionResult{
-					ScriptPath: script.Path,
-					ScriptName: script.Name,
-					Metrics: []lib.Metric{
-						lib.Metric{
-							Name:   lib.GetScriptName(script.Path),
-							Labels: script.Labels,
-							Value:  float64(i),
-							Type:   script.Type,
-							Help:   script.Help,
-						},
-					},
-					TotalExecTime: execTotalMs,
-				}
+	}
 
-			}
-			log.Error(execErr.Error())
+	stdout := &boundedBuffer{max: script.MaxOutputBytes}
+	stderr := &boundedBuffer{max: script.MaxOutputBytes}
+	result := runScriptProcess(script, timeout, cg, stdout, stderr)
+	result.Cgroup = cg.release()
+	result.Stdout = string(stdout.Bytes())
+	result.Stderr = string(stderr.Bytes())
+	result.OutputTruncated = stdout.truncated || stderr.truncated
+	if !result.OutputTruncated {
+		return result
+	}
+
+	// The last series of a truncated output could be cut short, so it isn't parsed
+	parsed := stdout
+	if script.ParseSource == "stderr" {
+		parsed = stderr
+	}
+	if parsed.truncated && script.OutputType != "exit_code" && result.Error == nil {
+		result.Metrics = nil
+		result.Error = newExecutionError(ReasonOutputTruncated, fmt.Errorf("Output of script exceeded %d bytes and was truncated", script.MaxOutputBytes))
+		return result
+	}
+	log.Warnf("Output of script %s exceeded %d bytes and was truncated", script.Path, script.MaxOutputBytes)
+	return result
+}
+
+// runScriptProcess starts the script process, with its output captured in stdout and
+// stderr, and parses its result
+func runScriptProcess(script config.Script, timeout time.Duration, cg *scriptCgroup, stdout, stderr *boundedBuffer) ExecutionResult {
+
+	execStart := time.Now()
+
+	log.Debugf("Running script %s (output type: %s) with timeout of %v", script.Path, script.OutputType, timeout)
+
+	cmd := exec.Command("/bin/bash", "-c", scriptCommand(script))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	setDeathSignal(cmd.SysProcAttr)
+	cg.attach(cmd)
+	if err := applyCredential(cmd, script); err != nil {
+		return ExecutionResult{
+			ScriptPath: script.Path,
+			Error:      err,
+		}
+	}
+	if err := applySandbox(cmd, script); err != nil {
+		return ExecutionResult{
+			ScriptPath: script.Path,
+			Error:      err,
+		}
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	timedOut, outErr := runProcessTree(cmd, script, timeout, cg)
+
+	duration := time.Since(execStart)
+	execTotalMs := duration.Milliseconds()
+
+	result := parseScriptResult(script, cmd, timedOut, outErr, stdout, stderr, execTotalMs)
+	result.Usage = resourceUsage(script, cmd.ProcessState)
+	return result
+}
+
+// parseScriptResult returns the result of the completed execution of the script, parsed
+// according to its output type
+func parseScriptResult(script config.Script, cmd *exec.Cmd, timedOut bool, outErr error, stdout, stderr *boundedBuffer, execTotalMs int64) ExecutionResult {
+
+	if timedOut {
+		return ExecutionResult{
+			ScriptPath:    script.Path,
+			Error:         newExecutionError(ReasonTimeout, errors.New("Script execution timed out")),
+			TotalExecTime: execTotalMs,
+		}
+	}
+	var setupErr *ExecutionError
+	if errors.As(outErr, &setupErr) {
+		return ExecutionResult{
+			ScriptPath:    script.Path,
+			Error:         outErr,
+			TotalExecTime: execTotalMs,
+		}
+	}
+	if limitErr := checkLimitExceeded(script, cmd.ProcessState); limitErr != nil {
+		return ExecutionResult{
+			ScriptPath:    script.Path,
+			Error:         limitErr,
+			TotalExecTime: execTotalMs,
+		}
+	}
+
+	if script.OutputType == "exit_code" {
+		_, isExitErr := outErr.(*exec.ExitError)
+		if outErr != nil && !isExitErr {
+			log.Error(outErr.Error())
 			return ExecutionResult{
 				ScriptPath:    script.Path,
 				Error:         errors.New("Could not get exit code"),
 				TotalExecTime: execTotalMs,
 			}
 		}
-		if exitError, ok := execErr.(*exec.ExitError); ok {
-			waitStatus = exitError.Sys().(syscall.WaitStatus)
-			log.Debugf("Success running script %s (output type: %s)", script.Path, script.OutputType)
+		waitStatus, _ := waitStatus(script, cmd.ProcessState)
+		if waitStatus.Signaled() {
 			return ExecutionResult{
-				ScriptPath: script.Path,
-				ScriptName: script.Name,
-				Metrics: []lib.Metric{
-					lib.Metric{
-						Name:   lib.GetScriptName(script.Path),
-						Labels: script.Labels,
-						Value:  float64(waitStatus.ExitStatus()),
-						Type:   script.Type,
-						Help:   script.Help,
-					},
-				},
+				ScriptPath:    script.Path,
+				Error:         newExecutionError(ReasonKilledBySignal, fmt.Errorf("Could not get exit code, script was terminated by %v", waitStatus.Signal())),
 				TotalExecTime: execTotalMs,
 			}
 		}
-		// Success
 		log.Debugf("Success running script %s (output type: %s)", script.Path, script.OutputType)
-		waitStatus = cmd.ProcessState.Sys().(syscall.WaitStatus)
 		return ExecutionResult{
 			ScriptPath: script.Path,
 			ScriptName: script.Name,
@@ -153,53 +295,11 @@ func RunScript(script config.Script) ExecutionResult {
 		}
 	}
 
-	log.Debugf("Running script %s (output type: %s) with timeout of %v", script.Path, script.OutputType, timeout)
-
-	// The following block of code is used instead of CommandContext as the context
-	// doesn't terminate sub-processes.
-	// See: https://github.com/golang/go/issues/22485
-
-	cmd := exec.Command("/bin/bash", "-c", script.Path)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	killChanRes := make(chan ExecutionResult, 1)
-
-	ctx := context.Background()
-	ctx, cancelTimeout := context.WithCancel(ctx)
-
-	go func(ctx context.Context) {
-		select {
-		case <-time.After(timeout):
-			log.Warnf("timeout of %v has passed for script %s (output type: %s). Killing script",
-				timeout,
-				script.Path,
-				script.OutputType)
-			duration := time.Since(execStart)
-			execTotalMs := duration.Milliseconds()
-			killChanRes <- ExecutionResult{
-				ScriptPath:    script.Path,
-				Error:         errors.New("Script execution timed out"),
-				TotalExecTime: execTotalMs,
-			}
-			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
-				log.Warnf("Error killing running script %s (output type: %s): %s",
-					script.Path,
-					script.OutputType,
-					err)
-			}
-		case <-ctx.Done():
-			log.Debugf("timeout canceled for %s (output type: %s)", script.Path, script.OutputType)
-		}
-	}(ctx)
-
-	output, outErr := cmd.Output()
-	if outErr == nil {
-		cancelTimeout()
+	output := stdout.Bytes()
+	if script.ParseSource == "stderr" {
+		output = stderr.Bytes()
 	}
 
-	duration := time.Since(execStart)
-	execTotalMs := duration.Milliseconds()
-
 	res := strings.TrimSuffix(string(output), "\n")
 	log.Debugf("Script output for %s (output type: %s) : %s",
 		script.Path,
@@ -209,7 +309,7 @@ func RunScript(script config.Script) ExecutionResult {
 	if outErr != nil && script.OutputType != "raw_series" {
 		return ExecutionResult{
 			ScriptPath:    script.Path,
-			Error:         fmt.Errorf("Could not get output: %v", outErr),
+			Error:         newExecutionError(exitFailureReason(script, outErr), fmt.Errorf("Could not get output: %v", outErr)),
 			TotalExecTime: execTotalMs,
 		}
 	}
@@ -219,7 +319,7 @@ func RunScript(script config.Script) ExecutionResult {
 		if len(metrics) == 0 {
 			return ExecutionResult{
 				ScriptPath: script.Path,
-				Error:      fmt.Errorf("No valid series detected in %s", script.Path),
+				Error:      newExecutionError(ReasonNoSeries, fmt.Errorf("No valid series detected in %s", script.Path)),
 			}
 		}
 		return ExecutionResult{
@@ -232,7 +332,7 @@ func RunScript(script config.Script) ExecutionResult {
 		if err != nil {
 			return ExecutionResult{
 				ScriptPath:    script.Path,
-				Error:         errors.New("Could not parse script output as float"),
+				Error:         newExecutionError(ReasonParseError, errors.New("Could not parse script output as float")),
 				TotalExecTime: execTotalMs,
 			}
 		}
@@ -262,7 +362,7 @@ func RunScript(script config.Script) ExecutionResult {
 	if err != nil {
 		return ExecutionResult{
 			ScriptPath:    script.Path,
-			Error:         errors.New("Could not parse output with regex"),
+			Error:         newExecutionError(ReasonParseError, errors.New("Could not parse output with regex")),
 			TotalExecTime: execTotalMs,
 		}
 	}
@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+// splayDelay returns the delay applied before the execution of the script, up to its
+// splay.  In daemon mode, the delay is limited so that the execution completes within its
+// interval, before the next one is due.  The delay is derived from a hash of the hostname
+// and the name of the script (or its path when it has no name), along with the target for
+// the executions of a script with targets, so it's the same at every run of a script on a
+// host while being spread across the hosts and targets.
+func splayDelay(script config.Script, interval time.Duration) time.Duration {
+	splay, _ := time.ParseDuration(script.Splay)
+	if interval > 0 {
+		timeout, _ := time.ParseDuration(script.Timeout)
+		if limit := interval - timeout; splay > limit {
+			splay = limit
+		}
+	}
+	if splay <= 0 {
+		return 0
+	}
+
+	id := script.Name
+	if id == "" {
+		id = script.Path
+	}
+	hostname, _ := os.Hostname()
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	h.Write([]byte{0})
+	h.Write([]byte(id))
+	if script.Target != "" {
+		h.Write([]byte{0})
+		h.Write([]byte(script.Target))
+	}
+	return time.Duration(h.Sum64() % uint64(splay))
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestSplayDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   config.Script
+		interval time.Duration
+		max      time.Duration
+	}{
+		{name: "no splay", script: config.Script{Name: "check_dns", Timeout: "10s"}},
+		{name: "one-shot", script: config.Script{Name: "check_dns", Splay: "30s", Timeout: "10s"}, max: 30 * time.Second},
+		{name: "within the interval", script: config.Script{Name: "check_dns", Splay: "30s", Timeout: "10s"}, interval: time.Minute, max: 30 * time.Second},
+		{name: "limited by the interval", script: config.Script{Name: "check_dns", Splay: "5m", Timeout: "10s"}, interval: time.Minute, max: 50 * time.Second},
+		{name: "timeout as long as the interval", script: config.Script{Name: "check_dns", Splay: "30s", Timeout: "1m"}, interval: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splayDelay(tt.script, tt.interval)
+			if got < 0 || (tt.max == 0 && got != 0) || (tt.max > 0 && got >= tt.max) {
+				t.Errorf("splayDelay() = %v, want in [0, %v)", got, tt.max)
+			}
+			if again := splayDelay(tt.script, tt.interval); again != got {
+				t.Errorf("splayDelay() = %v then %v, want the same delay", got, again)
+			}
+		})
+	}
+}
+
+func TestSplayDelaySpread(t *testing.T) {
+	const splay = "1h"
+	tests := []struct {
+		name string
+		a, b config.Script
+	}{
+		{
+			name: "names",
+			a:    config.Script{Name: "check_dns", Path: "/plugins/check", Splay: splay},
+			b:    config.Script{Name: "check_http", Path: "/plugins/check", Splay: splay},
+		},
+		{
+			name: "paths without names",
+			a:    config.Script{Path: "/plugins/check_dns", Splay: splay},
+			b:    config.Script{Path: "/plugins/check_http", Splay: splay},
+		},
+		{
+			name: "targets",
+			a:    config.Script{Name: "check_http", Target: "web1:80", Splay: splay},
+			b:    config.Script{Name: "check_http", Target: "web2:80", Splay: splay},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if splayDelay(tt.a, 0) == splayDelay(tt.b, 0) {
+				t.Errorf("splayDelay() is the same for %+v and %+v", tt.a, tt.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package executor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	"github.com/hartfordfive/n2p-script-executor/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// storedResult is the result of the last successful execution of a script
+type storedResult struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Metrics   []lib.Metric `json:"metrics"`
+}
+
+// resultStore keeps the metrics of the last successful execution of each script, so the
+// failure policy of a script can be applied when one of its executions fails.  It's held
+// in memory in daemon mode and persisted to the state file between one-shot runs.  The
+// results of the scripts with targets are kept by target.  Between one-shot runs, the
+// state file also holds the outcome of the writes to the outputs, which are reported by
+// the next run.
+type resultStore struct {
+	mu            sync.Mutex
+	Results       map[string]storedResult            `json:"results"`
+	TargetResults map[string]map[string]storedResult `json:"target_results,omitempty"`
+	Outputs       map[string]sink.WriteStats         `json:"outputs,omitempty"`
+	// failures counts the failures of each execution by reason when it's not nil
+	failures map[string]map[string]float64
+	// runs holds the result of the last execution of each execution, successful or not
+	runs map[string]lastRun
+	// running holds the executions in progress, scheduled or requested through the
+	// management API, and finished is signaled when one of them completes
+	running  map[string]bool
+	finished *sync.Cond
+}
+
+// lastRun is the result of the last execution of a script
+type lastRun struct {
+	Timestamp time.Time
+	Result    ExecutionResult
+}
+
+func newResultStore() *resultStore {
+	r := &resultStore{
+		Results:       map[string]storedResult{},
+		TargetResults: map[string]map[string]storedResult{},
+		runs:          map[string]lastRun{},
+		running:       map[string]bool{},
+	}
+	r.finished = sync.NewCond(&r.mu)
+	return r
+}
+
+// loadResultStore reads the store from the state file.  An empty store is returned when
+// no state file is configured or it can't be read.
+func loadResultStore(path string) *resultStore {
+	store := newResultStore()
+	if path == "" {
+		return store
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store
+	}
+	if err != nil {
+		log.Warnf("Could not read state file %s: %v", path, err)
+		return store
+	}
+	if err := json.Unmarshal(content, store); err != nil {
+		log.Warnf("Could not parse state file %s: %v", path, err)
+		return newResultStore()
+	}
+	if store.Results == nil {
+		store.Results = map[string]storedResult{}
+	}
+	if store.TargetResults == nil {
+		store.TargetResults = map[string]map[string]storedResult{}
+	}
+	store.runs = map[string]lastRun{}
+	return store
+}
+
+// save atomically writes the store to the state file, leaving out the scripts which
+// are no longer in the config
+func (r *resultStore) save(path string, scripts []config.Script) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := map[string]bool{}
+	for _, script := range scripts {
+		current[script.Path] = true
+	}
+	for scriptPath := range r.Results {
+		if !current[scriptPath] {
+			delete(r.Results, scriptPath)
+		}
+	}
+	for scriptPath := range r.TargetResults {
+		if !current[scriptPath] {
+			delete(r.TargetResults, scriptPath)
+		}
+	}
+
+	content, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return lib.AtomicWriteFile(path, string(content), 0600, -1, -1)
+}
+
+// keep drops the results and failure counts of the scripts other than the given ones,
+// which were removed from the config or changed
+func (r *resultStore) keep(scripts map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for scriptPath := range r.Results {
+		if !scripts[scriptPath] {
+			delete(r.Results, scriptPath)
+		}
+	}
+	for scriptPath := range r.TargetResults {
+		if !scripts[scriptPath] {
+			delete(r.TargetResults, scriptPath)
+		}
+	}
+	for key := range r.failures {
+		if !scripts[keyPath(key)] {
+			delete(r.failures, key)
+		}
+	}
+	for key := range r.runs {
+		if !scripts[keyPath(key)] {
+			delete(r.runs, key)
+		}
+	}
+}
+
+// keepTargets drops the results and failure counts of the targets the scripts with targets
+// are no longer executed against
+func (r *resultStore) keepTargets(scripts []config.Script, executions []config.Script) {
+	paths := map[string]bool{}
+	for _, script := range scripts {
+		if script.HasTargets() {
+			paths[script.Path] = true
+		}
+	}
+	current := map[string]bool{}
+	for _, execution := range executions {
+		current[executionKey(execution.Path, execution.Target)] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for scriptPath := range paths {
+		for target := range r.TargetResults[scriptPath] {
+			if !current[executionKey(scriptPath, target)] {
+				delete(r.TargetResults[scriptPath], target)
+			}
+		}
+	}
+	for key := range r.failures {
+		if paths[keyPath(key)] && !current[key] {
+			delete(r.failures, key)
+		}
+	}
+	for key := range r.runs {
+		if paths[keyPath(key)] && !current[key] {
+			delete(r.runs, key)
+		}
+	}
+}
+
+// startRun marks the execution as running, so the same script isn't executed twice at
+// the same time.  When the execution is already running, it waits for it to complete if
+// wait is set, otherwise false is returned.
+func (r *resultStore) startRun(key string, wait bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.running[key] {
+		if !wait {
+			return false
+		}
+		r.finished.Wait()
+	}
+	r.running[key] = true
+	return true
+}
+
+// finishRun marks the execution as completed
+func (r *resultStore) finishRun(key string) {
+	r.mu.Lock()
+	delete(r.running, key)
+	r.mu.Unlock()
+	r.finished.Broadcast()
+}
+
+// recordRun records the result of the last execution
+func (r *resultStore) recordRun(res ExecutionResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[executionKey(res.ScriptPath, res.Target)] = lastRun{Timestamp: time.Now(), Result: res}
+}
+
+// lastRun returns the result of the last execution of the script for the target, if any
+func (r *resultStore) lastRun(scriptPath, target string) (lastRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run, ok := r.runs[executionKey(scriptPath, target)]
+	return run, ok
+}
+
+// targetRuns returns the result of the last execution of the script for each of its targets
+func (r *resultStore) targetRuns(scriptPath string) map[string]lastRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	runs := map[string]lastRun{}
+	for key, run := range r.runs {
+		if keyPath(key) == scriptPath && run.Result.Target != "" {
+			runs[run.Result.Target] = run
+		}
+	}
+	return runs
+}
+
+// result returns the result of the last successful execution of the script
+func (r *resultStore) result(script config.Script) (storedResult, bool) {
+	if script.Target == "" {
+		res, ok := r.Results[script.Path]
+		return res, ok
+	}
+	res, ok := r.TargetResults[script.Path][script.Target]
+	return res, ok
+}
+
+// deleteResult drops the result of the last successful execution of the script
+func (r *resultStore) deleteResult(script config.Script) {
+	if script.Target == "" {
+		delete(r.Results, script.Path)
+		return
+	}
+	delete(r.TargetResults[script.Path], script.Target)
+}
+
+// update records the metrics of a successful execution of the script
+func (r *resultStore) update(script config.Script, metrics []lib.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := storedResult{
+		Timestamp: time.Now(),
+		Metrics:   metrics,
+	}
+	if script.Target == "" {
+		r.Results[script.Path] = result
+		return
+	}
+	if r.TargetResults[script.Path] == nil {
+		r.TargetResults[script.Path] = map[string]storedResult{}
+	}
+	r.TargetResults[script.Path][script.Target] = result
+}
+
+// countFailures enables counting the failures of each script by reason, which is only
+// done in daemon mode as the counts would otherwise restart with every run
+func (r *resultStore) countFailures() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = map[string]map[string]float64{}
+}
+
+// recordFailures adds the failure of the execution, if any, to its failure counts and
+// returns them.  nil is returned when failures aren't counted.
+func (r *resultStore) recordFailures(key string, reason string) map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures == nil {
+		return nil
+	}
+	counts, ok := r.failures[key]
+	if !ok {
+		counts = map[string]float64{}
+		for _, r := range failureReasons {
+			counts[r] = 0
+		}
+		r.failures[key] = counts
+	}
+	if reason != "" {
+		counts[reason]++
+	}
+	result := make(map[string]float64, len(counts))
+	for r, count := range counts {
+		result[r] = count
+	}
+	return result
+}
+
+// onFailure returns the metrics to report for a failed execution of the script, according
+// to its failure policy:
+//   - drop: no metrics are reported
+//   - carry_forward: the metrics of the last successful execution are reported, as long as
+//     it's not older than max_stale
+//   - sentinel: the metrics of the last successful execution (or the metric named after the
+//     script when there is none) are reported with the sentinel value
+func (r *resultStore) onFailure(script config.Script) []lib.Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, ok := r.result(script)
+
+	switch script.OnFailure {
+	case "carry_forward":
+		maxStale, _ := time.ParseDuration(script.MaxStale)
+		if !ok {
+			return nil
+		}
+		if time.Since(last.Timestamp) > maxStale {
+			log.Debugf("Last successful result of script %s%s is older than %v, dropping it", script.Path, targetSuffix(script.Target), maxStale)
+			r.deleteResult(script)
+			return nil
+		}
+		log.Debugf("Carrying forward the last successful result of script %s%s", script.Path, targetSuffix(script.Target))
+		return last.Metrics
+	case "sentinel":
+		if !ok || len(last.Metrics) == 0 {
+			labels := map[string]string{"script": script.Path}
+			for k, v := range script.Labels {
+				labels[k] = v
+			}
+			return []lib.Metric{
+				lib.Metric{
+					Name:   lib.GetScriptName(script.Path),
+					Labels: labels,
+					Value:  *script.SentinelValue,
+					Type:   script.Type,
+					Help:   script.Help,
+				},
+			}
+		}
+		metrics := make([]lib.Metric, len(last.Metrics))
+		for i, metric := range last.Metrics {
+			metric.Value = *script.SentinelValue
+			metrics[i] = metric
+		}
+		return metrics
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	"github.com/hartfordfive/n2p-script-executor/sink"
+)
+
+func TestResultStoreOnFailure(t *testing.T) {
+	sentinel := 3.0
+	previous := []lib.Metric{
+		{Name: "check_disk_used", Labels: map[string]string{"script": "/plugins/check_disk"}, Value: 42},
+		{Name: "check_disk_free", Labels: map[string]string{"script": "/plugins/check_disk"}, Value: 58},
+	}
+
+	tests := []struct {
+		name   string
+		policy string
+		target string
+		// age is the age of the previous result, none is stored when it's 0
+		age         time.Duration
+		want        []lib.Metric
+		wantDeleted bool
+	}{
+		{name: "drop", policy: "drop", age: time.Minute},
+		{name: "carry_forward within max_stale", policy: "carry_forward", age: time.Minute, want: previous},
+		{name: "carry_forward beyond max_stale", policy: "carry_forward", age: time.Hour, wantDeleted: true},
+		{name: "carry_forward without a previous result", policy: "carry_forward"},
+		{name: "carry_forward of a target within max_stale", policy: "carry_forward", target: "db01", age: time.Minute, want: previous},
+		{name: "carry_forward of a target beyond max_stale", policy: "carry_forward", target: "db01", age: time.Hour, wantDeleted: true},
+		{
+			name:   "sentinel with a previous result",
+			policy: "sentinel",
+			age:    time.Hour,
+			want: []lib.Metric{
+				{Name: "check_disk_used", Labels: map[string]string{"script": "/plugins/check_disk"}, Value: sentinel},
+				{Name: "check_disk_free", Labels: map[string]string{"script": "/plugins/check_disk"}, Value: sentinel},
+			},
+		},
+		{
+			name:   "sentinel without a previous result",
+			policy: "sentinel",
+			want: []lib.Metric{
+				{Name: "check_disk", Labels: map[string]string{"script": "/plugins/check_disk", "team": "storage"}, Value: sentinel, Type: "gauge"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := config.Script{
+				Path:          "/plugins/check_disk",
+				Target:        tt.target,
+				OnFailure:     tt.policy,
+				MaxStale:      "10m",
+				SentinelValue: &sentinel,
+				Type:          "gauge",
+				Labels:        map[string]string{"team": "storage"},
+			}
+			r := newResultStore()
+			if tt.age > 0 {
+				r.update(script, previous)
+				stored, _ := r.result(script)
+				stored.Timestamp = stored.Timestamp.Add(-tt.age)
+				if tt.target == "" {
+					r.Results[script.Path] = stored
+				} else {
+					r.TargetResults[script.Path][script.Target] = stored
+				}
+			}
+
+			if got := r.onFailure(script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected the metrics %+v, got %+v", tt.want, got)
+			}
+			wantStored := tt.age > 0 && !tt.wantDeleted
+			if _, ok := r.result(script); ok != wantStored {
+				t.Errorf("expected the previous result to be stored: %v, got %v", wantStored, ok)
+			}
+			// The previous result itself isn't changed by the sentinel
+			if stored, ok := r.result(script); ok && !reflect.DeepEqual(stored.Metrics, previous) {
+				t.Errorf("the previous result was changed to %+v", stored.Metrics)
+			}
+		})
+	}
+}
+
+func TestResultStoreStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	kept := config.Script{Path: "/plugins/check_load"}
+	targets := config.Script{Path: "/plugins/check_http", Targets: []string{"web01"}}
+	removed := config.Script{Path: "/plugins/check_old"}
+	removedTargets := config.Script{Path: "/plugins/check_old_http", Targets: []string{"web02"}}
+
+	r := newResultStore()
+	r.update(kept, []lib.Metric{{Name: "check_load", Labels: map[string]string{"script": kept.Path}, Value: 0.5, Type: "gauge"}})
+	r.update(config.Script{Path: targets.Path, Target: "web01"}, []lib.Metric{{Name: "check_http", Value: 0}})
+	r.update(removed, []lib.Metric{{Name: "check_old", Value: 1}})
+	r.update(config.Script{Path: removedTargets.Path, Target: "web02"}, []lib.Metric{{Name: "check_old_http", Value: 2}})
+	r.Outputs = map[string]sink.WriteStats{"textfile": {Successes: 3, Failures: 1}}
+	if err := r.save(path, []config.Script{kept, targets}); err != nil {
+		t.Fatalf("could not save the state file: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the state file to be written with mode 0600, got %v (%v)", info, err)
+	}
+
+	loaded := loadResultStore(path)
+	if len(loaded.Results) != 1 || len(loaded.TargetResults) != 1 {
+		t.Fatalf("expected the results of the scripts in the config only, got %+v and %+v", loaded.Results, loaded.TargetResults)
+	}
+	if !reflect.DeepEqual(loaded.Results[kept.Path].Metrics, r.Results[kept.Path].Metrics) {
+		t.Errorf("expected the metrics %+v, got %+v", r.Results[kept.Path].Metrics, loaded.Results[kept.Path].Metrics)
+	}
+	if !loaded.Results[kept.Path].Timestamp.Equal(r.Results[kept.Path].Timestamp) {
+		t.Errorf("expected the timestamp %v, got %v", r.Results[kept.Path].Timestamp, loaded.Results[kept.Path].Timestamp)
+	}
+	if _, ok := loaded.TargetResults[targets.Path]["web01"]; !ok {
+		t.Errorf("expected the result of target web01, got %+v", loaded.TargetResults)
+	}
+	if !reflect.DeepEqual(loaded.Outputs, r.Outputs) {
+		t.Errorf("expected the output stats %+v, got %+v", r.Outputs, loaded.Outputs)
+	}
+}
+
+func TestLoadResultStoreFallsBackToEmpty(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(empty, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "no state file", path: ""},
+		{name: "missing state file", path: filepath.Join(dir, "missing.json")},
+		{name: "invalid state file", path: invalid},
+		{name: "empty state file", path: empty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := loadResultStore(tt.path)
+			if r.Results == nil || r.TargetResults == nil || r.runs == nil || r.running == nil {
+				t.Fatalf("expected an initialized store, got %+v", r)
+			}
+			if len(r.Results) != 0 || len(r.TargetResults) != 0 {
+				t.Errorf("expected an empty store, got %+v", r)
+			}
+			// The store can be used right away
+			script := config.Script{Path: "/plugins/check_load", Target: "web01"}
+			r.update(script, nil)
+			if _, ok := r.result(script); !ok {
+				t.Errorf("expected the result to be stored")
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// targetFile is a loaded target file, which is only parsed again once it's modified
+type targetFile struct {
+	modTime time.Time
+	size    int64
+	groups  []config.TargetGroup
+}
+
+// targetFiles caches the target files of the scripts.  The targets last loaded from a
+// file are kept when it can't be parsed after being modified.
+var targetFiles = struct {
+	sync.Mutex
+	files map[string]targetFile
+}{files: map[string]targetFile{}}
+
+// executionKey returns the key identifying an execution of a script, which is the path of
+// the script, along with the target for the executions expanded from its targets
+func executionKey(path, target string) string {
+	if target == "" {
+		return path
+	}
+	return path + "\x00" + target
+}
+
+// keyPath returns the path of the script of the execution key
+func keyPath(key string) string {
+	return strings.SplitN(key, "\x00", 2)[0]
+}
+
+// targetSuffix returns the suffix added to the logs about an execution for the target
+func targetSuffix(target string) string {
+	if target == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (target: %s)", target)
+}
+
+// expandTargets returns the executions of the scripts.  A script without targets is
+// executed once, while a script with targets is executed once per target, with the
+// target in place of {{target}} in its args, and the target and its labels attached to
+// its series.
+func expandTargets(scripts []config.Script) []config.Script {
+	executions := make([]config.Script, 0, len(scripts))
+	for _, script := range scripts {
+		if !script.HasTargets() {
+			executions = append(executions, script)
+			continue
+		}
+
+		groups := append([]config.TargetGroup{{Targets: script.Targets}}, loadTargetFiles(script.TargetFiles)...)
+		seen := map[string]bool{}
+		for _, group := range groups {
+			for _, target := range group.Targets {
+				if seen[target] {
+					log.Warnf("Target %s of script %s is listed more than once, ignoring the duplicates", target, script.Path)
+					continue
+				}
+				seen[target] = true
+				executions = append(executions, targetScript(script, target, group.Labels))
+			}
+		}
+		if len(seen) == 0 {
+			log.Warnf("Script %s has no targets, it isn't executed", script.Path)
+		}
+	}
+	return executions
+}
+
+// targetScript returns the execution of the script against the target
+func targetScript(script config.Script, target string, targetLabels map[string]string) config.Script {
+	labels := make(map[string]string, len(script.Labels)+len(targetLabels)+1)
+	for k, v := range script.Labels {
+		labels[k] = v
+	}
+	for k, v := range targetLabels {
+		labels[k] = v
+	}
+	labels["target"] = target
+
+	script.Args = targetArgs(script.Args, target)
+	script.Labels = labels
+	script.Target = target
+	script.TargetLabels = targetLabels
+	return script
+}
+
+// targetArgs returns the args with the target in place of {{target}}
+func targetArgs(args []string, target string) []string {
+	replaced := make([]string, len(args))
+	for i, arg := range args {
+		replaced[i] = strings.ReplaceAll(arg, config.TargetPlaceholder, target)
+	}
+	return replaced
+}
+
+// loadTargetFiles returns the target groups of the files matching the patterns
+func loadTargetFiles(patterns []string) []config.TargetGroup {
+	targetFiles.Lock()
+	defer targetFiles.Unlock()
+
+	groups := []config.TargetGroup{}
+	for _, pattern := range patterns {
+		paths, _ := filepath.Glob(pattern)
+		if len(paths) == 0 {
+			log.Warnf("No target file matches %s", pattern)
+		}
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil {
+				log.Warnf("Could not read target file %s: %v", path, err)
+				continue
+			}
+			cached, ok := targetFiles.files[path]
+			if !ok || !fi.ModTime().Equal(cached.modTime) || fi.Size() != cached.size {
+				loaded, err := config.LoadTargetFile(path)
+				if err != nil && ok {
+					log.Errorf("Could not reload target file, keeping its previous targets: %v", err)
+				} else if err != nil {
+					log.Errorf("Could not load target file: %v", err)
+				} else {
+					log.Infof("Loaded %d target groups from target file %s", len(loaded), path)
+					cached.groups = loaded
+				}
+				cached.modTime = fi.ModTime()
+				cached.size = fi.Size()
+				targetFiles.files[path] = cached
+			}
+			groups = append(groups, cached.groups...)
+		}
+	}
+	return groups
+}
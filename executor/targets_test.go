@@ -0,0 +1,96 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestExpandTargets(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"web.json": `[{"targets": ["web1:80", "web2:80"], "labels": {"env": "prod"}}]`,
+		"db.yml":   "- targets: [\"db1:5432\"]\n  labels:\n    env: staging\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type execution struct {
+		Target string
+		Args   []string
+		Labels map[string]string
+	}
+	tests := []struct {
+		name   string
+		script config.Script
+		want   []execution
+	}{
+		{
+			name:   "no targets",
+			script: config.Script{Path: "/plugins/check_load", Args: []string{"-w", "5"}, Labels: map[string]string{"team": "ops"}},
+			want:   []execution{{Args: []string{"-w", "5"}, Labels: map[string]string{"team": "ops"}}},
+		},
+		{
+			name:   "inline targets",
+			script: config.Script{Path: "/plugins/check_http", Args: []string{"-H", "{{target}}"}, Targets: []string{"a.example.com", "b.example.com"}},
+			want: []execution{
+				{Target: "a.example.com", Args: []string{"-H", "a.example.com"}, Labels: map[string]string{"target": "a.example.com"}},
+				{Target: "b.example.com", Args: []string{"-H", "b.example.com"}, Labels: map[string]string{"target": "b.example.com"}},
+			},
+		},
+		{
+			name:   "duplicate targets",
+			script: config.Script{Path: "/plugins/check_http", Args: []string{"{{target}}"}, Targets: []string{"a", "a"}},
+			want:   []execution{{Target: "a", Args: []string{"a"}, Labels: map[string]string{"target": "a"}}},
+		},
+		{
+			name: "target file labels",
+			script: config.Script{
+				Path:        "/plugins/check_tcp",
+				Args:        []string{"-H", "{{target}}"},
+				Labels:      map[string]string{"team": "ops", "env": "unknown"},
+				TargetFiles: []string{filepath.Join(dir, "web.json")},
+			},
+			want: []execution{
+				{Target: "web1:80", Args: []string{"-H", "web1:80"}, Labels: map[string]string{"team": "ops", "env": "prod", "target": "web1:80"}},
+				{Target: "web2:80", Args: []string{"-H", "web2:80"}, Labels: map[string]string{"team": "ops", "env": "prod", "target": "web2:80"}},
+			},
+		},
+		{
+			name: "inline targets before the files",
+			script: config.Script{
+				Path:        "/plugins/check_tcp",
+				Args:        []string{"{{target}}"},
+				Targets:     []string{"db1:5432"},
+				TargetFiles: []string{filepath.Join(dir, "*.yml")},
+			},
+			want: []execution{{Target: "db1:5432", Args: []string{"db1:5432"}, Labels: map[string]string{"target": "db1:5432"}}},
+		},
+		{
+			name:   "no matching file",
+			script: config.Script{Path: "/plugins/check_tcp", TargetFiles: []string{filepath.Join(dir, "*.yaml")}},
+			want:   []execution{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []execution{}
+			for _, s := range expandTargets([]config.Script{tt.script}) {
+				if s.Path != tt.script.Path {
+					t.Errorf("path = %s, want %s", s.Path, tt.script.Path)
+				}
+				got = append(got, execution{Target: s.Target, Args: s.Args, Labels: s.Labels})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandTargets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
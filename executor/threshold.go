@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// stateSeries returns the state series of the metrics of the script which have thresholds,
+// named after the metric with the _state suffix.  The state is CRITICAL when the value
+// alerts in the critical range, WARNING when it alerts in the warning range and OK otherwise.
+func stateSeries(script config.Script, metrics []lib.Metric) []lib.Metric {
+	if script.OutputType != "stdout" && script.OutputType != "multi_metric" {
+		return nil
+	}
+
+	scriptName := lib.GetScriptName(script.Path)
+	series := []lib.Metric{}
+	for _, metric := range metrics {
+		if metric.Name == "" {
+			continue
+		}
+		// The metrics of multi_metric scripts are named after their capture group
+		capture := ""
+		if script.OutputType == "multi_metric" {
+			capture = strings.TrimPrefix(metric.Name, scriptName+"_")
+		}
+		threshold := script.MetricThreshold(capture)
+		if !threshold.IsSet() {
+			continue
+		}
+
+		state := metricState(threshold, metric.Value)
+		if state != lib.StateOK {
+			log.Debugf("Value %v of metric %s of script %s%s is in state %d", metric.Value, metric.Name, script.Path, targetSuffix(script.Target), state)
+		}
+		series = append(series, lib.Metric{
+			Name:   metric.Name + "_state",
+			Labels: metric.Labels,
+			Value:  float64(state),
+			Type:   "gauge",
+			Help:   fmt.Sprintf("indicates the state of %s from its warning and critical thresholds (0: OK, 1: WARNING, 2: CRITICAL)", metric.Name),
+		})
+	}
+	return series
+}
+
+// metricState returns the Nagios state of the value according to the threshold
+func metricState(threshold config.Threshold, value float64) int {
+	if threshold.Critical != "" {
+		if r, err := lib.ParseNagiosRange(threshold.Critical); err == nil && r.Alerts(value) {
+			return lib.StateCritical
+		}
+	}
+	if threshold.Warning != "" {
+		if r, err := lib.ParseNagiosRange(threshold.Warning); err == nil && r.Alerts(value) {
+			return lib.StateWarning
+		}
+	}
+	return lib.StateOK
+}
@@ -0,0 +1,91 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func TestMetricState(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold config.Threshold
+		value     float64
+		want      int
+	}{
+		{name: "ok", threshold: config.Threshold{Warning: "80", Critical: "90"}, value: 50, want: lib.StateOK},
+		{name: "warning", threshold: config.Threshold{Warning: "80", Critical: "90"}, value: 85, want: lib.StateWarning},
+		{name: "critical", threshold: config.Threshold{Warning: "80", Critical: "90"}, value: 95, want: lib.StateCritical},
+		{name: "start ranges, warning", threshold: config.Threshold{Warning: "20:", Critical: "10:"}, value: 15, want: lib.StateWarning},
+		{name: "start ranges, critical", threshold: config.Threshold{Warning: "20:", Critical: "10:"}, value: 5, want: lib.StateCritical},
+		{name: "~ range", threshold: config.Threshold{Critical: "~:0"}, value: -100, want: lib.StateOK},
+		{name: "@ range", threshold: config.Threshold{Critical: "@1:2"}, value: 1.5, want: lib.StateCritical},
+		{name: "only warning", threshold: config.Threshold{Warning: "10"}, value: 11, want: lib.StateWarning},
+		{name: "invalid range", threshold: config.Threshold{Critical: "x"}, value: 100, want: lib.StateOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metricState(tt.threshold, tt.value); got != tt.want {
+				t.Errorf("expected state %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStateSeries(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  config.Script
+		metrics []lib.Metric
+		want    map[string]float64
+	}{
+		{
+			name:    "stdout",
+			script:  config.Script{Path: "/bin/check_load", OutputType: "stdout", Warning: "5", Critical: "10"},
+			metrics: []lib.Metric{{Name: "check_load", Value: 7}},
+			want:    map[string]float64{"check_load_state": lib.StateWarning},
+		},
+		{
+			name:    "stdout without thresholds",
+			script:  config.Script{Path: "/bin/check_load", OutputType: "stdout"},
+			metrics: []lib.Metric{{Name: "check_load", Value: 7}},
+			want:    map[string]float64{},
+		},
+		{
+			name: "multi_metric",
+			script: config.Script{
+				Path:       "/bin/check_disk",
+				OutputType: "multi_metric",
+				Thresholds: map[string]config.Threshold{"used": {Critical: "90"}, "free": {Warning: "10:"}},
+			},
+			metrics: []lib.Metric{
+				{Name: "check_disk_used", Value: 95},
+				{Name: "check_disk_free", Value: 20},
+				{Name: "check_disk_inodes", Value: 1000},
+			},
+			want: map[string]float64{"check_disk_used_state": lib.StateCritical, "check_disk_free_state": lib.StateOK},
+		},
+		{
+			name:    "exit_code",
+			script:  config.Script{Path: "/bin/check_dns", OutputType: "exit_code", Critical: "1"},
+			metrics: []lib.Metric{{Name: "check_dns", Value: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := stateSeries(tt.script, tt.metrics)
+			if len(series) != len(tt.want) {
+				t.Fatalf("expected %d state series, got %+v", len(tt.want), series)
+			}
+			for _, s := range series {
+				want, ok := tt.want[s.Name]
+				if !ok || s.Value != want {
+					t.Errorf("unexpected state series %s = %v", s.Name, s.Value)
+				}
+			}
+		})
+	}
+}
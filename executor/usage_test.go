@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestUsageSeries(t *testing.T) {
+	const mib = 1 << 20
+
+	tests := []struct {
+		name    string
+		command string
+		// wantMinRSS is the least maximum RSS of the script, in bytes
+		wantMinRSS float64
+		wantSignal float64
+	}{
+		{name: "exits", command: "exit 0", wantMinRSS: mib},
+		// The string held by the shell takes at least 32MiB
+		{name: "allocates memory", command: `x=$(head -c 33554432 /dev/zero | tr '\0' a); exit 0`, wantMinRSS: 32 * mib},
+		{name: "killed by SIGKILL", command: "kill -KILL $$", wantMinRSS: mib, wantSignal: 9},
+		{name: "killed by SIGTERM", command: "kill -TERM $$", wantMinRSS: mib, wantSignal: 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := config.Script{
+				Path:           "eval",
+				Args:           []string{tt.command},
+				OutputType:     "exit_code",
+				KillGrace:      "1s",
+				MaxOutputBytes: 1024,
+			}
+			res := runScriptAttempt(script, 10*time.Second)
+			if res.Usage == nil {
+				t.Fatalf("no resource usage reported (error: %v)", res.Error)
+			}
+			res.ScriptPath = script.Path
+
+			series := map[string]float64{}
+			for _, metric := range usageSeries(res, script) {
+				if metric.Labels["script"] != script.Path || metric.Source != script.Path {
+					t.Errorf("series %s has labels %v and source %q", metric.Name, metric.Labels, metric.Source)
+				}
+				series[metric.Name] = metric.Value
+			}
+			// A value in kilobytes, or multiplied by 1024 once too many, would be way off
+			if rss := series["script_last_max_rss_bytes"]; rss < tt.wantMinRSS || rss > 1024*mib {
+				t.Errorf("script_last_max_rss_bytes = %v, want between %v and %v", rss, tt.wantMinRSS, 1024*mib)
+			}
+			if signal := series["script_last_terminating_signal"]; signal != tt.wantSignal {
+				t.Errorf("script_last_terminating_signal = %v, want %v", signal, tt.wantSignal)
+			}
+			if len(series) != 8 {
+				t.Errorf("got %d usage series, want 8", len(series))
+			}
+		})
+	}
+}
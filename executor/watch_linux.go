@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// watchConfig returns a channel notified when the directory of the config file changes.
+// The directory is watched, rather than the file, so the file being replaced, as done by
+// most editors and config management tools, is noticed too.  Notifications are delayed
+// so a change made in several writes is only notified once.
+func watchConfig(path string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		log.Warnf("Could not watch config file %s for changes: %v", path, err)
+		return changes
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		syscall.Close(fd)
+		log.Warnf("Could not watch config file %s for changes: %v", path, err)
+		return changes
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, 4096)
+		for {
+			if _, err := syscall.Read(fd, buf); err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				log.Warnf("Stopped watching config file %s for changes: %v", path, err)
+				return
+			}
+			time.Sleep(500 * time.Millisecond)
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
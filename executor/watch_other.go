@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import (
+	"os"
+	"time"
+)
+
+// watchConfig returns a channel notified when the config file changes, which is checked
+// every few seconds as inotify is only available on Linux
+func watchConfig(path string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		var lastMod time.Time
+		if fi, err := os.Stat(path); err == nil {
+			lastMod = fi.ModTime()
+		}
+		for range time.Tick(5 * time.Second) {
+			fi, err := os.Stat(path)
+			if err != nil || fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
@@ -1,6 +1,7 @@
 package executor
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -8,32 +9,136 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// WorkQueue is the struct for the work queue
+// task is a script waiting to be executed by a worker
+type task struct {
+	script    config.Script
+	submitted time.Time
+}
+
+// groupLimiter limits the number of scripts of each concurrency group executed at the
+// same time, by the workers of the queue as well as through the management API.  The
+// workers wait for a slot on its lock and condition, which also guard the pending
+// scripts of the queue.
+type groupLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limits  map[string]int
+	running map[string]int
+}
+
+func newGroupLimiter(limits map[string]int) *groupLimiter {
+	l := &groupLimiter{limits: limits, running: map[string]int{}}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// available returns true when a script of the group can be executed.  Must be called
+// with the lock held.
+func (l *groupLimiter) available(group string) bool {
+	limit, limited := l.limits[group]
+	return !limited || l.running[group] < limit
+}
+
+// acquire blocks until a script of the group can be executed and takes its slot.  False
+// is returned when the context is done first.
+func (l *groupLimiter) acquire(ctx context.Context, group string) bool {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.available(group) {
+		if ctx.Err() != nil {
+			return false
+		}
+		l.cond.Wait()
+	}
+	l.running[group]++
+	return true
+}
+
+// release releases the slot held by a script in its group
+func (l *groupLimiter) release(group string) {
+	l.mu.Lock()
+	l.running[group]--
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// setLimits replaces the limits of the groups, when the config is reloaded
+func (l *groupLimiter) setLimits(limits map[string]int) {
+	l.mu.Lock()
+	l.limits = limits
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// WorkQueue is the struct for the work queue. Scripts are executed by a pool of workers
+// in submission order, except that a script is held back while its concurrency group
+// already has as many scripts executing as the limit of the group.
 type WorkQueue struct {
-	numWorkers            int
-	pendingScriptWorkChan chan config.Script
-	ResultsChan           chan ExecutionResult
-	doneChan              chan interface{}
-	Wg                    *sync.WaitGroup
+	numWorkers int
+	// groups limits the scripts executed at the same time by concurrency group, and its
+	// lock guards pending and shutdown
+	groups      *groupLimiter
+	pending     []task
+	shutdown    bool
+	ResultsChan chan ExecutionResult
+	Wg          *sync.WaitGroup
+	// runs, when set, keeps a script from being executed while it's already running
+	// outside of the queue
+	runs *resultStore
 }
 
 // NewWorkQueue returns a new instance of WorkQueue
-func NewWorkQueue(capacity int, maxWorkers int, totalScripts int) *WorkQueue {
-	wq := &WorkQueue{
-		numWorkers:            maxWorkers,
-		pendingScriptWorkChan: make(chan config.Script, capacity),
-		ResultsChan:           make(chan ExecutionResult, capacity),
-		doneChan:              make(chan interface{}, 1),
-		Wg:                    &sync.WaitGroup{},
+func NewWorkQueue(maxWorkers int, groupLimits map[string]int, totalScripts int) *WorkQueue {
+	return newWorkQueue(maxWorkers, newGroupLimiter(groupLimits), totalScripts)
+}
+
+// newWorkQueue returns a new instance of WorkQueue sharing the limits of the concurrency
+// groups with the scripts executed outside of it
+func newWorkQueue(maxWorkers int, groups *groupLimiter, totalScripts int) *WorkQueue {
+	return &WorkQueue{
+		numWorkers:  maxWorkers,
+		groups:      groups,
+		ResultsChan: make(chan ExecutionResult, totalScripts),
+		Wg:          &sync.WaitGroup{},
 	}
-	return wq
 }
 
-// SubmitTask adds a new script execution task to the channel
+// SubmitTask adds a new script execution task to the queue
 func (w *WorkQueue) SubmitTask(script config.Script) {
-	log.Debug("Submiting script ", script.Path, " to be executed...")
+	w.SubmitTaskAfter(script, 0)
+}
+
+// SubmitTaskAfter adds a new script execution task to the queue once the delay has
+// passed. The task is accounted for in the wait group right away.
+func (w *WorkQueue) SubmitTaskAfter(script config.Script, delay time.Duration) {
 	w.Wg.Add(1)
-	w.pendingScriptWorkChan <- script
+	if delay > 0 {
+		log.Debugf("Delaying the submission of script %s by %v", script.Path, delay)
+		time.AfterFunc(delay, func() { w.enqueue(script) })
+		return
+	}
+	w.enqueue(script)
+}
+
+func (w *WorkQueue) enqueue(script config.Script) {
+	log.Debug("Submiting script ", script.Path, " to be executed...")
+	w.groups.mu.Lock()
+	w.pending = append(w.pending, task{script: script, submitted: time.Now()})
+	w.groups.mu.Unlock()
+	// The condition is shared with the scripts executed outside of the queue
+	w.groups.cond.Broadcast()
 	log.Debug("Script submitted")
 }
 
@@ -45,25 +150,74 @@ func (w *WorkQueue) Process() {
 	}
 }
 
-func (w *WorkQueue) execWorker(id int) {
-	ticker := time.NewTicker(2 * time.Second)
+// Shutdown stops the workers once they are done with the script they are executing
+func (w *WorkQueue) Shutdown() {
+	w.groups.mu.Lock()
+	w.shutdown = true
+	w.groups.mu.Unlock()
+	w.groups.cond.Broadcast()
+}
+
+// next blocks until a pending script can be executed without exceeding the limit of its
+// concurrency group, and removes it from the queue. False is returned when the queue is shut down.
+func (w *WorkQueue) next() (task, bool) {
+	w.groups.mu.Lock()
+	defer w.groups.mu.Unlock()
 	for {
-		select {
-		case script := <-w.pendingScriptWorkChan:
-			log.Debugf("[Worker #%d] Running script %s", id, script.Path)
-			scriptResult := RunScript(script)
-
-			if scriptResult.Error != nil {
-				log.Errorf("[Worker #%d] Encountered error executing script %s (Error: %v)", id, script.Name, scriptResult.Error)
-			} else {
-				log.Debugf("[Worker #%d] Script %s completed execution. Result: %v", id, script.Name, scriptResult)
+		if w.shutdown {
+			return task{}, false
+		}
+		for i, t := range w.pending {
+			if !w.groups.available(t.script.ConcurrencyGroup) {
+				continue
 			}
-			w.ResultsChan <- scriptResult
-		case <-w.doneChan:
+			w.pending = append(w.pending[:i], w.pending[i+1:]...)
+			w.groups.running[t.script.ConcurrencyGroup]++
+			return t, true
+		}
+		w.groups.cond.Wait()
+	}
+}
+
+// done releases the slot held by the script in its concurrency group
+func (w *WorkQueue) done(t task) {
+	w.groups.release(t.script.ConcurrencyGroup)
+}
+
+func (w *WorkQueue) execWorker(id int) {
+	for {
+		t, ok := w.next()
+		if !ok {
 			log.Debugf("[Worker #%d] Shutting down", id)
 			return
-		case <-ticker.C:
-			log.Tracef("[Worker #%d] Waiting for work", id)
 		}
+
+		queueWait := time.Since(t.submitted)
+		script := t.script
+		log.Debugf("[Worker #%d] Running script %s (waited %v in queue)", id, script.Path, queueWait)
+		key := executionKey(script.Path, script.Target)
+		if w.runs != nil {
+			w.runs.startRun(key, true)
+		}
+		scriptResult := RunScript(script)
+		if w.runs != nil {
+			w.runs.finishRun(key)
+		}
+		scriptResult.QueueWaitTime = queueWait.Milliseconds()
+		w.done(t)
+
+		if scriptResult.Error != nil && scriptResult.Stderr != "" {
+			log.Errorf("[Worker #%d] Encountered error executing script %s%s (Error: %v, Stderr: %s)",
+				id,
+				script.Name,
+				targetSuffix(script.Target),
+				scriptResult.Error,
+				outputSnippet(scriptResult.Stderr))
+		} else if scriptResult.Error != nil {
+			log.Errorf("[Worker #%d] Encountered error executing script %s%s (Error: %v)", id, script.Name, targetSuffix(script.Target), scriptResult.Error)
+		} else {
+			log.Debugf("[Worker #%d] Script %s completed execution. Result: %v", id, script.Name, scriptResult)
+		}
+		w.ResultsChan <- scriptResult
 	}
 }
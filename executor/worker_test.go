@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestWorkQueueConcurrencyGroups(t *testing.T) {
+	w := NewWorkQueue(4, map[string]int{"db": 1}, 4)
+	for _, s := range []config.Script{
+		{Path: "/db1", ConcurrencyGroup: "db"},
+		{Path: "/db2", ConcurrencyGroup: "db"},
+		{Path: "/other"},
+	} {
+		w.enqueue(s)
+	}
+
+	first, _ := w.next()
+	second, _ := w.next()
+	if first.script.Path != "/db1" || second.script.Path != "/other" {
+		t.Fatalf("got %s then %s, want /db1 then /other as /db2 waits for /db1", first.script.Path, second.script.Path)
+	}
+
+	next := make(chan task)
+	go func() {
+		t, _ := w.next()
+		next <- t
+	}()
+	select {
+	case early := <-next:
+		t.Fatalf("%s was executed while /db1 holds the only slot of the db group", early.script.Path)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w.done(first)
+	select {
+	case third := <-next:
+		if third.script.Path != "/db2" {
+			t.Errorf("got %s, want /db2", third.script.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("/db2 wasn't executed once /db1 was done")
+	}
+	w.Shutdown()
+}
+
+func TestWorkQueueShutdown(t *testing.T) {
+	w := NewWorkQueue(1, nil, 0)
+	done := make(chan bool)
+	go func() {
+		_, ok := w.next()
+		done <- ok
+	}()
+	w.Shutdown()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("next() returned a task after the shutdown")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("next() is still blocked after the shutdown")
+	}
+}
+
+func TestGroupLimiter(t *testing.T) {
+	l := newGroupLimiter(map[string]int{"db": 1})
+	w := newWorkQueue(1, l, 1)
+	if !l.acquire(context.Background(), "db") {
+		t.Fatal("acquire() = false with a slot left")
+	}
+	if !l.acquire(context.Background(), "other") {
+		t.Fatal("acquire() = false for a group without a limit")
+	}
+
+	// The workers of a queue sharing the limiter wait for the slot too
+	w.enqueue(config.Script{Path: "/db", ConcurrencyGroup: "db"})
+	next := make(chan task)
+	go func() {
+		t, _ := w.next()
+		next <- t
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx, "db") {
+		t.Fatal("acquire() = true while the only slot of the group is in use")
+	}
+	select {
+	case early := <-next:
+		t.Fatalf("%s was executed while the only slot of the db group is in use", early.script.Path)
+	default:
+	}
+
+	l.release("db")
+	select {
+	case <-next:
+	case <-time.After(time.Second):
+		t.Fatal("/db wasn't executed once the slot was released")
+	}
+	if l.running["db"] != 1 || l.running["other"] != 1 {
+		t.Errorf("slots in use = %v, want 1 in each group", l.running)
+	}
+
+	l.setLimits(map[string]int{"db": 2})
+	if !l.acquire(context.Background(), "db") {
+		t.Fatal("acquire() = false once the limit is raised")
+	}
+	w.Shutdown()
+}
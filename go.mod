@@ -1,6 +1,6 @@
 module github.com/hartfordfive/n2p-script-executor
 
-go 1.14
+go 1.20
 
 require (
 	github.com/go-yaml/yaml v2.1.0+incompatible
@@ -8,5 +8,13 @@ require (
 	github.com/prometheus/prometheus v2.5.0+incompatible
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.0.0
+)
+
+require (
+	github.com/cespare/xxhash v1.1.0 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	golang.org/x/sys v0.0.0-20190422165155-953cdadca894 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "every minute", expr: "* * * * *"},
+		{name: "lists, ranges and steps", expr: "0,30 8-18/2 1-15 */3 1-5"},
+		{name: "names", expr: "0 0 * jan-mar MON-fri"},
+		{name: "sunday as 7", expr: "0 0 * * 7"},
+		{name: "macro", expr: "@daily"},
+		{name: "too few fields", expr: "* * * *", wantErr: true},
+		{name: "too many fields", expr: "* * * * * *", wantErr: true},
+		{name: "minute out of range", expr: "60 * * * *", wantErr: true},
+		{name: "day of month out of range", expr: "0 0 0 * *", wantErr: true},
+		{name: "inverted range", expr: "0 18-8 * * *", wantErr: true},
+		{name: "invalid step", expr: "*/0 * * * *", wantErr: true},
+		{name: "invalid value", expr: "0 0 * foo *", wantErr: true},
+		{name: "unknown macro", expr: "@every5m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCronSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCronSchedule(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2024-01-15 was a Monday
+	from := time.Date(2024, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{name: "every minute", expr: "* * * * *", from: from, want: time.Date(2024, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{name: "on the minute", expr: "* * * * *", from: time.Date(2024, 1, 15, 10, 7, 0, 0, time.UTC), want: time.Date(2024, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{name: "step", expr: "*/15 * * * *", from: from, want: time.Date(2024, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{name: "next hour", expr: "5 * * * *", from: from, want: time.Date(2024, 1, 15, 11, 5, 0, 0, time.UTC)},
+		{name: "next day", expr: "0 9 * * *", from: from, want: time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{name: "weekday", expr: "0 8 * * sat", from: from, want: time.Date(2024, 1, 20, 8, 0, 0, 0, time.UTC)},
+		{name: "sunday as 7", expr: "0 8 * * 7", from: from, want: time.Date(2024, 1, 21, 8, 0, 0, 0, time.UTC)},
+		{name: "next month", expr: "0 0 1 * *", from: from, want: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "next year", expr: "@yearly", from: from, want: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "leap day", expr: "0 0 29 2 *", from: from, want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "either day when both are restricted", expr: "0 0 20 * mon", from: from, want: time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{name: "both days with a star", expr: "0 0 */2 * mon", from: from, want: time.Date(2024, 1, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "never", expr: "0 0 31 2 *", from: from, want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCronSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCronSchedule(%q) error = %v", tt.expr, err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"os"
+	"os/user"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/renameio"
@@ -25,18 +29,47 @@ type Metric struct {
 	Value  float64
 	Type   string
 	Help   string
+	// Source is the path of the script the metric originates from, empty for the
+	// series describing the executor itself
+	Source string
+	// Carried is set on the series of the last execution of a script reported again
+	// because the script wasn't executed
+	Carried bool `json:"-"`
 }
 
-var seriesPrefix = "n2p_script_exec"
+// DefaultSeriesPrefix is the prefix of the created series when none is configured
+const DefaultSeriesPrefix = "n2p_script_exec"
+
+// seriesPrefix is updated when the config is reloaded, while the series are written by
+// the outputs, so it's guarded by seriesPrefixMu
+var (
+	seriesPrefix   = DefaultSeriesPrefix
+	seriesPrefixMu sync.RWMutex
+)
 
 // SetSeriesPrefix updates the prefix of the created series.
 func SetSeriesPrefix(prefix string) {
 	if len(prefix) >= 1 {
+		seriesPrefixMu.Lock()
 		seriesPrefix = prefix
+		seriesPrefixMu.Unlock()
 	}
 }
 
+// GetSeriesPrefix returns the prefix of the created series.
+func GetSeriesPrefix() string {
+	seriesPrefixMu.RLock()
+	defer seriesPrefixMu.RUnlock()
+	return seriesPrefix
+}
+
+// FullName returns the name of the metric with the series prefix applied
+func (m Metric) FullName() string {
+	return fmt.Sprintf("%s_%s", GetSeriesPrefix(), m.Name)
+}
+
 func (m Metric) String(addHelp bool) string {
+	seriesPrefix := GetSeriesPrefix()
 	output := ""
 	if addHelp {
 		output += fmt.Sprintf("# TYPE %s_%s %s\n", seriesPrefix, m.Name, m.Type)
@@ -94,21 +127,65 @@ func GetScriptName(path string) string {
 
 // WriteToFile dumps the data to the destination file atomically
 func WriteToFile(file string, data string) bool {
-	write := func(data string) error {
-		t, err := renameio.TempFile("", file)
-		if err != nil {
+	if err := AtomicWriteFile(file, data, 0, -1, -1); err != nil {
+		return false
+	}
+	return true
+}
+
+// AtomicWriteFile writes the data to a temporary file in the destination directory,
+// applies the mode (unless 0) and ownership (unless -1) to it, then fsyncs and renames
+// it over the destination file
+func AtomicWriteFile(file string, data string, mode os.FileMode, uid int, gid int) error {
+	t, err := renameio.TempFile(filepath.Dir(file), file)
+	if err != nil {
+		return err
+	}
+	defer t.Cleanup()
+	if _, err := t.WriteString(data); err != nil {
+		return err
+	}
+	if mode != 0 {
+		if err := t.Chmod(mode); err != nil {
 			return err
 		}
-		defer t.Cleanup()
-		if _, err := fmt.Fprintf(t, data); err != nil {
+	}
+	if uid != -1 || gid != -1 {
+		if err := t.Chown(uid, gid); err != nil {
 			return err
 		}
-		return t.CloseAtomicallyReplace()
 	}
-	if err := write(data); err != nil {
-		return false
+	return t.CloseAtomicallyReplace()
+}
+
+// LookupOwner returns the uid and gid matching an owner in the "user[:group]" format,
+// either by name or id.  -1 is returned for the parts that aren't specified.
+func LookupOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner == "" {
+		return uid, gid, nil
 	}
-	return true
+
+	parts := strings.SplitN(owner, ":", 2)
+	if parts[0] != "" {
+		u, err := user.Lookup(parts[0])
+		if err != nil {
+			if u, err = user.LookupId(parts[0]); err != nil {
+				return uid, gid, err
+			}
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			if g, err = user.LookupGroupId(parts[1]); err != nil {
+				return uid, gid, err
+			}
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
 }
 
 // ReturnRegexCaptures accepts a regex pattern and returns a map with the matches
@@ -152,14 +229,21 @@ func convertToIntString(val float64) string {
 	return strconv.FormatInt(int64(val), 10)
 }
 
-// GenerateSeries takes the array of TextfileCollectorMetric and writes them to the destination file
-func GenerateSeries(metrics []Metric, execSuccess []string) string {
+// GenerateSeries takes the array of metrics and renders them in the Prometheus text format.
+// Metrics sharing the same name are grouped together so each family is contiguous.
+func GenerateSeries(metrics []Metric) string {
+
+	sorted := make([]Metric, len(metrics))
+	copy(sorted, metrics)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
 
 	data := ""
 	typeHelpLine := map[string]bool{}
 	addHelp := false
 
-	for _, metric := range metrics {
+	for _, metric := range sorted {
 		if _, ok := typeHelpLine[metric.Name]; !ok {
 			addHelp = true
 			typeHelpLine[metric.Name] = true
@@ -168,7 +252,7 @@ func GenerateSeries(metrics []Metric, execSuccess []string) string {
 		if !metric.IsValidMetricName() {
 			log.Warnf("Metric %s has an invalid name. Skipping it.", metric.Name)
 		} else if !metric.ValidSeriesLabels() {
-			log.Warnf("Metric %s has invalid labels. Removing labels.")
+			log.Warnf("Metric %s has invalid labels. Removing labels.", metric.Name)
 			metric.Labels = map[string]string{}
 		} else {
 			log.Debugf("Metric name '%s' is valid!", metric.Name)
@@ -177,41 +261,47 @@ func GenerateSeries(metrics []Metric, execSuccess []string) string {
 		}
 	}
 
-	data += fmt.Sprintf("# TYPE %s_lastrun counter\n", seriesPrefix)
-	data += fmt.Sprintf("# HELP %s_lastrun Time when the script was last executed\n", seriesPrefix)
-	for _, script := range execSuccess {
-		data += generateScriptCheckpointMetric(script) + "\n"
-	}
-	data += GenerateExecutorCheckpointMetric() + "\n"
-
-	data += fmt.Sprintf("# TYPE %s_build_info counter\n", seriesPrefix)
-	data += fmt.Sprintf("# HELP %s_build_info Build information of the script executor\n", seriesPrefix)
-	data += fmt.Sprintf(
-		"%s_build_info{version=\"%s\", commit_hash=\"%s\", build_date=\"%s\", go_version=\"%s\"} 1\n",
-		seriesPrefix,
-		version.Version,
-		version.CommitHash,
-		version.BuildDate,
-		runtime.Version(),
-	)
-
 	return data
 }
 
-func generateScriptCheckpointMetric(scriptName string) string {
-	return fmt.Sprintf(
-		"%s_lastrun{script=\"%s\"} %d",
-		seriesPrefix,
-		scriptName,
-		(time.Now().UnixNano() / int64(time.Millisecond)),
-	)
-}
+// ExecutorSeries returns the series describing the executor itself: the time each
+// successful execution of a script last happened, the time of the last execution and the
+// build info.  execSuccess holds the labels of each successful execution, with the path
+// of the script in the script label.
+func ExecutorSeries(execSuccess []map[string]string) []Metric {
+	now := float64(time.Now().UnixNano() / int64(time.Millisecond))
+	series := make([]Metric, 0, len(execSuccess)+2)
+
+	for _, labels := range execSuccess {
+		series = append(series, Metric{
+			Name:   "lastrun",
+			Labels: labels,
+			Value:  now,
+			Type:   "counter",
+			Help:   "Time when the script was last executed",
+			Source: labels["script"],
+		})
+	}
+
+	series = append(series, Metric{
+		Name:  "last_execution",
+		Value: now,
+		Type:  "gauge",
+		Help:  "Time when the executor was last executed",
+	})
+
+	series = append(series, Metric{
+		Name: "build_info",
+		Labels: map[string]string{
+			"version":     version.Version,
+			"commit_hash": version.CommitHash,
+			"build_date":  version.BuildDate,
+			"go_version":  runtime.Version(),
+		},
+		Value: 1,
+		Type:  "counter",
+		Help:  "Build information of the script executor",
+	})
 
-// GenerateExecutorCheckpointMetric is ...
-func GenerateExecutorCheckpointMetric() string {
-	return fmt.Sprintf(
-		"%s_last_execution %d",
-		seriesPrefix,
-		(time.Now().UnixNano() / int64(time.Millisecond)),
-	)
+	return series
 }
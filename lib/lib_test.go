@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSeriesPrefix(t *testing.T) {
+	defer SetSeriesPrefix(DefaultSeriesPrefix)
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "custom prefix", prefix: "nagios", want: "nagios_check_disk"},
+		{name: "empty prefix keeps the current one", prefix: "", want: "nagios_check_disk"},
+		{name: "default prefix", prefix: DefaultSeriesPrefix, want: "n2p_script_exec_check_disk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetSeriesPrefix(tt.prefix)
+			if got := (Metric{Name: "check_disk"}).FullName(); got != tt.want {
+				t.Errorf("FullName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSeriesPrefixConcurrentReload is meant to be run with the race detector
+func TestSeriesPrefixConcurrentReload(t *testing.T) {
+	defer SetSeriesPrefix(DefaultSeriesPrefix)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			SetSeriesPrefix("reloaded")
+			SetSeriesPrefix(DefaultSeriesPrefix)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		m := Metric{Name: "check_disk", Value: 1}
+		for i := 0; i < 100; i++ {
+			m.FullName()
+			m.String(true)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestExecutorSeriesLastRun(t *testing.T) {
+	tests := []struct {
+		name        string
+		execSuccess []map[string]string
+		want        []map[string]string
+	}{
+		{name: "no successful execution", want: []map[string]string{}},
+		{
+			name: "one per execution",
+			execSuccess: []map[string]string{
+				{"script": "/plugins/check_load"},
+				{"script": "/plugins/check_http", "target": "web1:80", "env": "prod"},
+				{"script": "/plugins/check_http", "target": "web2:80", "env": "prod"},
+			},
+			want: []map[string]string{
+				{"script": "/plugins/check_load"},
+				{"script": "/plugins/check_http", "target": "web1:80", "env": "prod"},
+				{"script": "/plugins/check_http", "target": "web2:80", "env": "prod"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []map[string]string{}
+			for _, m := range ExecutorSeries(tt.execSuccess) {
+				if m.Name != "lastrun" {
+					continue
+				}
+				if m.Source != m.Labels["script"] {
+					t.Errorf("source = %q, want the script %q", m.Source, m.Labels["script"])
+				}
+				got = append(got, m.Labels)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lastrun labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Nagios states derived from the thresholds of a value
+const (
+	StateOK       = 0
+	StateWarning  = 1
+	StateCritical = 2
+)
+
+// NagiosRange is a parsed threshold range in the Nagios plugin syntax, [@]start:end
+type NagiosRange struct {
+	start float64
+	end   float64
+	// inside is set when the range starts with @, so values within the range alert
+	inside bool
+}
+
+// ParseNagiosRange parses a threshold range in the Nagios plugin syntax:
+//   - 10 alerts when the value is < 0 or > 10
+//   - 10: alerts when the value is < 10
+//   - ~:10 alerts when the value is > 10
+//   - 10:20 alerts when the value is < 10 or > 20
+//   - @10:20 alerts when the value is >= 10 and <= 20
+func ParseNagiosRange(value string) (*NagiosRange, error) {
+	r := &NagiosRange{start: 0, end: math.Inf(1)}
+	spec := strings.TrimSpace(value)
+	if strings.HasPrefix(spec, "@") {
+		r.inside = true
+		spec = spec[1:]
+	}
+	if spec == "" {
+		return nil, fmt.Errorf("invalid range '%s'", value)
+	}
+
+	start, end := "", spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		start, end = spec[:i], spec[i+1:]
+	}
+	var err error
+	if start == "~" {
+		r.start = math.Inf(-1)
+	} else if start != "" {
+		if r.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return nil, fmt.Errorf("invalid start '%s' of range '%s'", start, value)
+		}
+	}
+	if end != "" {
+		if r.end, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, fmt.Errorf("invalid end '%s' of range '%s'", end, value)
+		}
+	}
+	if r.start > r.end {
+		return nil, fmt.Errorf("start of range '%s' is greater than its end", value)
+	}
+	return r, nil
+}
+
+// Alerts returns true when the value is outside of the range, or within it for the
+// ranges starting with @
+func (r *NagiosRange) Alerts(v float64) bool {
+	within := v >= r.start && v <= r.end
+	return within == r.inside
+}
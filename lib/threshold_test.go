@@ -0,0 +1,84 @@
+package lib
+
+import "testing"
+
+func TestParseNagiosRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "end", value: "10"},
+		{name: "start", value: "10:"},
+		{name: "negative infinity start", value: "~:10"},
+		{name: "start and end", value: "10:20"},
+		{name: "inside", value: "@10:20"},
+		{name: "inside negative infinity", value: "@~:0"},
+		{name: "unbounded", value: "~:"},
+		{name: "decimals", value: "-1.5:2.5"},
+		{name: "surrounding spaces", value: " 10:20 "},
+		{name: "empty", value: "", wantErr: true},
+		{name: "only @", value: "@", wantErr: true},
+		{name: "invalid start", value: "a:10", wantErr: true},
+		{name: "invalid end", value: "10:b", wantErr: true},
+		{name: "~ as end", value: "10:~", wantErr: true},
+		{name: "start greater than end", value: "20:10", wantErr: true},
+		{name: "negative end", value: "-5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseNagiosRange(tt.value)
+			if tt.wantErr && err == nil {
+				t.Errorf("expected an error parsing %q", tt.value)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error parsing %q: %v", tt.value, err)
+			}
+		})
+	}
+}
+
+func TestNagiosRangeAlerts(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		v     float64
+		want  bool
+	}{
+		{name: "end, below 0", value: "10", v: -1, want: true},
+		{name: "end, at 0", value: "10", v: 0},
+		{name: "end, at end", value: "10", v: 10},
+		{name: "end, above end", value: "10", v: 10.5, want: true},
+		{name: "start, below start", value: "10:", v: 9.9, want: true},
+		{name: "start, at start", value: "10:", v: 10},
+		{name: "start, far above start", value: "10:", v: 1e9},
+		{name: "~, far below end", value: "~:10", v: -1e9},
+		{name: "~, at end", value: "~:10", v: 10},
+		{name: "~, above end", value: "~:10", v: 11, want: true},
+		{name: "start and end, below", value: "10:20", v: 5, want: true},
+		{name: "start and end, within", value: "10:20", v: 15},
+		{name: "start and end, above", value: "10:20", v: 25, want: true},
+		{name: "@, below", value: "@10:20", v: 5},
+		{name: "@, at start", value: "@10:20", v: 10, want: true},
+		{name: "@, within", value: "@10:20", v: 15, want: true},
+		{name: "@, at end", value: "@10:20", v: 20, want: true},
+		{name: "@, above", value: "@10:20", v: 25},
+		{name: "@~, below end", value: "@~:0", v: -3, want: true},
+		{name: "@~, above end", value: "@~:0", v: 1},
+		{name: "@ start, above start", value: "@10:", v: 50, want: true},
+		{name: "@ start, below start", value: "@10:", v: 5},
+		{name: "unbounded", value: "~:", v: -1e9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseNagiosRange(tt.value)
+			if err != nil {
+				t.Fatalf("unexpected error parsing %q: %v", tt.value, err)
+			}
+			if got := r.Alerts(tt.v); got != tt.want {
+				t.Errorf("expected %q to alert on %v: %v, got %v", tt.value, tt.v, tt.want, got)
+			}
+		})
+	}
+}
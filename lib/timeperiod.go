@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeRanges holds the time ranges of a time period for days of the week, and for
+// specific dates which take precedence over their day of the week
+type TimeRanges struct {
+	weekdays map[time.Weekday][]minuteRange
+	dates    map[string][]minuteRange
+}
+
+// minuteRange is a range of minutes of the day, the end being excluded
+type minuteRange struct {
+	start int
+	end   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// ParseTimeRanges parses the ranges of a time period.  The keys are either days of
+// the week (e.g. monday) or dates (e.g. 2020-12-25), and the values comma separated
+// lists of time ranges (e.g. 09:00-12:00,13:00-17:00).
+func ParseTimeRanges(ranges map[string]string) (*TimeRanges, error) {
+	r := &TimeRanges{
+		weekdays: map[time.Weekday][]minuteRange{},
+		dates:    map[string][]minuteRange{},
+	}
+	for day, value := range ranges {
+		dayRanges, err := parseMinuteRanges(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time ranges '%s' for %s: %v", value, day, err)
+		}
+		if weekday, ok := weekdays[strings.ToLower(day)]; ok {
+			r.weekdays[weekday] = dayRanges
+		} else if date, err := time.Parse("2006-01-02", day); err == nil {
+			r.dates[date.Format("2006-01-02")] = dayRanges
+		} else {
+			return nil, fmt.Errorf("'%s' is neither a day of the week nor a date (YYYY-MM-DD)", day)
+		}
+	}
+	return r, nil
+}
+
+func parseMinuteRanges(value string) ([]minuteRange, error) {
+	ranges := []minuteRange{}
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.Split(strings.TrimSpace(part), "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("'%s' is not a range of times (HH:MM-HH:MM)", part)
+		}
+		start, err := parseMinuteOfDay(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseMinuteOfDay(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if start >= end {
+			return nil, fmt.Errorf("range '%s' ends before it starts", part)
+		}
+		ranges = append(ranges, minuteRange{start: start, end: end})
+	}
+	return ranges, nil
+}
+
+func parseMinuteOfDay(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("'%s' is not a time (HH:MM)", value)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a time (HH:MM)", value)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a time (HH:MM)", value)
+	}
+	if hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("'%s' is not a time between 00:00 and 24:00", value)
+	}
+	return hour*60 + minute, nil
+}
+
+// Contains returns true when t is within one of the ranges of its date or, when its
+// date has no ranges, of its day of the week
+func (r *TimeRanges) Contains(t time.Time) bool {
+	ranges, ok := r.dates[t.Format("2006-01-02")]
+	if !ok {
+		ranges = r.weekdays[t.Weekday()]
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, mr := range ranges {
+		if minute >= mr.start && minute < mr.end {
+			return true
+		}
+	}
+	return false
+}
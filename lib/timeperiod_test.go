@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		ranges  map[string]string
+		wantErr bool
+	}{
+		{name: "weekdays", ranges: map[string]string{"monday": "09:00-12:00,13:00-17:00", "Friday": "00:00-24:00"}},
+		{name: "date", ranges: map[string]string{"2024-12-25": "10:00-11:00"}},
+		{name: "unknown day", ranges: map[string]string{"someday": "09:00-17:00"}, wantErr: true},
+		{name: "not a range", ranges: map[string]string{"monday": "09:00"}, wantErr: true},
+		{name: "not a time", ranges: map[string]string{"monday": "9-17"}, wantErr: true},
+		{name: "minutes out of range", ranges: map[string]string{"monday": "09:60-17:00"}, wantErr: true},
+		{name: "after midnight", ranges: map[string]string{"monday": "09:00-24:01"}, wantErr: true},
+		{name: "ends before it starts", ranges: map[string]string{"monday": "17:00-09:00"}, wantErr: true},
+		{name: "empty range", ranges: map[string]string{"monday": "09:00-09:00"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTimeRanges(tt.ranges)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTimeRanges(%v) error = %v, wantErr %v", tt.ranges, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTimeRangesContains(t *testing.T) {
+	r, err := ParseTimeRanges(map[string]string{
+		"monday":     "09:00-12:00,13:00-17:00",
+		"sunday":     "00:00-24:00",
+		"2024-01-22": "10:00-11:00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-01-15 and 2024-01-22 were Mondays
+	tests := []struct {
+		name string
+		time time.Time
+		want bool
+	}{
+		{name: "start of a range", time: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), want: true},
+		{name: "within a range", time: time.Date(2024, 1, 15, 11, 59, 59, 0, time.UTC), want: true},
+		{name: "end of a range", time: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), want: false},
+		{name: "second range", time: time.Date(2024, 1, 15, 16, 30, 0, 0, time.UTC), want: true},
+		{name: "before the ranges", time: time.Date(2024, 1, 15, 8, 59, 0, 0, time.UTC), want: false},
+		{name: "day without ranges", time: time.Date(2024, 1, 16, 10, 0, 0, 0, time.UTC), want: false},
+		{name: "whole day", time: time.Date(2024, 1, 21, 23, 59, 0, 0, time.UTC), want: true},
+		{name: "date within its range", time: time.Date(2024, 1, 22, 10, 30, 0, 0, time.UTC), want: true},
+		{name: "date overrides its weekday", time: time.Date(2024, 1, 22, 9, 30, 0, 0, time.UTC), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Contains(tt.time); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.time, got, tt.want)
+			}
+		})
+	}
+}
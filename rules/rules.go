@@ -0,0 +1,196 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+// groupName is the name of the rule group holding the generated rules
+const groupName = "n2p-script-executor"
+
+// scheduleHorizon is how far the runs of a scheduled script are looked at to find the
+// longest time between two of them
+const scheduleHorizon = 366 * 24 * time.Hour
+
+// RuleFile is a Prometheus rule file
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a group of rules of a Prometheus rule file
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a Prometheus alerting rule
+type Rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Generate returns the Prometheus rule file with the alerting rules of the scripts, based
+// on their alert settings.  For each script, alerts are raised when:
+//   - ScriptStateNotOK: the state of the check isn't OK, for the exit_code scripts and the
+//     metrics with thresholds
+//   - ScriptExecutionFailed: the last execution of the script failed
+//   - ScriptResultStale: the script hasn't succeeded for staleFactor times the interval the
+//     scripts are executed at, or the longest time between two runs of its schedule
+//
+// Probe scripts and the scripts with disabled alerts are left out.
+func Generate(cnf *config.Config, interval time.Duration, staleFactor float64) ([]byte, error) {
+	if interval <= 0 {
+		return nil, errors.New("interval must be > 0")
+	}
+	if staleFactor < 1 {
+		return nil, fmt.Errorf("stale factor must be >= 1 (value passed: %v)", staleFactor)
+	}
+
+	prefix := cnf.SeriesPrefix
+	if prefix == "" {
+		prefix = lib.DefaultSeriesPrefix
+	}
+
+	group := RuleGroup{Name: groupName, Rules: []Rule{}}
+	for _, script := range cnf.Scripts {
+		if script.Probe || script.Alert.Disabled {
+			continue
+		}
+		rules, err := scriptRules(script, prefix, interval, staleFactor)
+		if err != nil {
+			return nil, err
+		}
+		group.Rules = append(group.Rules, rules...)
+	}
+
+	return yaml.Marshal(RuleFile{Groups: []RuleGroup{group}})
+}
+
+// scriptRules returns the alerting rules of the script
+func scriptRules(script config.Script, prefix string, interval time.Duration, staleFactor float64) ([]Rule, error) {
+	selector := fmt.Sprintf("{script=%s}", strconv.Quote(script.Path))
+	target := "{{ with $labels.target }} for {{ . }}{{ end }}"
+	rules := []Rule{}
+
+	for _, state := range stateMetrics(script) {
+		summary := fmt.Sprintf("Check %s%s is not OK (state {{ $value }})", script.Name, target)
+		if state.capture != "" {
+			summary = fmt.Sprintf("Check %s%s is not OK for %s (state {{ $value }})", script.Name, target, state.capture)
+		}
+		rules = append(rules, newRule(script, "ScriptStateNotOK",
+			fmt.Sprintf("%s_%s%s != 0", prefix, state.metric, selector),
+			summary))
+	}
+
+	rules = append(rules, newRule(script, "ScriptExecutionFailed",
+		fmt.Sprintf("%s_script_last_run_success%s == 0", prefix, selector),
+		fmt.Sprintf("Execution of check %s%s failed", script.Name, target)))
+
+	stale, err := staleAfter(script, interval, staleFactor)
+	if err != nil {
+		return nil, err
+	}
+	expr := fmt.Sprintf("time() * 1000 - %s_lastrun%s > %d", prefix, selector, stale.Milliseconds())
+	if script.CheckPeriod != "" {
+		// The script isn't executed outside of its check period
+		expr = fmt.Sprintf("(%s) and on(script) %s_script_in_active_period%s == 1", expr, prefix, selector)
+	}
+	rules = append(rules, newRule(script, "ScriptResultStale", expr,
+		fmt.Sprintf("Check %s hasn't succeeded for more than %v", script.Name, stale)))
+
+	return rules, nil
+}
+
+// stateMetric is a metric holding a Nagios state of a script
+type stateMetric struct {
+	metric string
+	// capture is the capture group of the metric of multi_metric scripts
+	capture string
+}
+
+// stateMetrics returns the metrics holding the Nagios states of the script, which are the
+// exit code of exit_code scripts, and the state series derived from the thresholds of the
+// metrics of stdout and multi_metric scripts
+func stateMetrics(script config.Script) []stateMetric {
+	name := lib.GetScriptName(script.Path)
+	switch script.OutputType {
+	case "exit_code":
+		return []stateMetric{{metric: name}}
+	case "stdout":
+		if script.MetricThreshold("").IsSet() {
+			return []stateMetric{{metric: name + "_state"}}
+		}
+	case "multi_metric":
+		re, err := regexp.Compile(script.MetricsRegex)
+		if err != nil {
+			return nil
+		}
+		states := []stateMetric{}
+		for _, capture := range re.SubexpNames() {
+			if capture != "" && script.MetricThreshold(capture).IsSet() {
+				states = append(states, stateMetric{metric: name + "_" + capture + "_state", capture: capture})
+			}
+		}
+		return states
+	}
+	return nil
+}
+
+// newRule returns the alerting rule of the script with its alert settings.  The summary
+// is used when none is configured for the alert.
+func newRule(script config.Script, alert string, expr string, summary string) Rule {
+	if s, ok := script.Alert.Summaries[alert]; ok {
+		summary = s
+	} else if script.Alert.Summary != "" {
+		summary = script.Alert.Summary
+	}
+	rule := Rule{
+		Alert:  alert,
+		Expr:   expr,
+		For:    script.Alert.For,
+		Labels: map[string]string{"severity": script.Alert.Severity},
+		Annotations: map[string]string{
+			"summary": summary,
+		},
+	}
+	if script.Alert.RunbookURL != "" {
+		rule.Annotations["runbook_url"] = script.Alert.RunbookURL
+	}
+	return rule
+}
+
+// staleAfter returns the time after which the last successful execution of the script is
+// stale, which is staleFactor times the interval or, for a scheduled script, the longest
+// time between two of its runs when it's longer
+func staleAfter(script config.Script, interval time.Duration, staleFactor float64) (time.Duration, error) {
+	period := interval
+	if script.Schedule != "" {
+		schedule, err := lib.ParseCronSchedule(script.Schedule)
+		if err != nil {
+			return 0, fmt.Errorf("invalid schedule for script '%s': %v", script.Path, err)
+		}
+		start := time.Now()
+		prev := schedule.Next(start)
+		for !prev.IsZero() && prev.Sub(start) < scheduleHorizon {
+			next := schedule.Next(prev)
+			if next.IsZero() {
+				break
+			}
+			if gap := next.Sub(prev); gap > period {
+				period = gap
+			}
+			prev = next
+		}
+	}
+	return time.Duration(float64(period) * staleFactor).Round(time.Second), nil
+}
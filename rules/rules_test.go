@@ -0,0 +1,224 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/hartfordfive/n2p-script-executor/config"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name        string
+		scripts     []config.Script
+		interval    time.Duration
+		staleFactor float64
+		wantAlerts  []string
+		wantErr     string
+	}{
+		{
+			name:        "exit_code script",
+			scripts:     []config.Script{{Name: "dns", Path: "/bin/check_dns", OutputType: "exit_code"}},
+			interval:    time.Minute,
+			staleFactor: 3,
+			wantAlerts:  []string{"ScriptStateNotOK", "ScriptExecutionFailed", "ScriptResultStale"},
+		},
+		{
+			name:        "stdout script without thresholds",
+			scripts:     []config.Script{{Name: "load", Path: "/bin/check_load", OutputType: "stdout"}},
+			interval:    time.Minute,
+			staleFactor: 3,
+			wantAlerts:  []string{"ScriptExecutionFailed", "ScriptResultStale"},
+		},
+		{
+			name:        "stdout script with thresholds",
+			scripts:     []config.Script{{Name: "load", Path: "/bin/check_load", OutputType: "stdout", Warning: "5", Critical: "10"}},
+			interval:    time.Minute,
+			staleFactor: 3,
+			wantAlerts:  []string{"ScriptStateNotOK", "ScriptExecutionFailed", "ScriptResultStale"},
+		},
+		{
+			name: "multi_metric script",
+			scripts: []config.Script{{
+				Name:         "disk",
+				Path:         "/bin/check_disk",
+				OutputType:   "multi_metric",
+				MetricsRegex: `used=(?P<used>\d+) free=(?P<free>\d+)`,
+				Thresholds:   map[string]config.Threshold{"used": {Critical: "90"}, "free": {Warning: "10:"}},
+			}},
+			interval:    time.Minute,
+			staleFactor: 3,
+			wantAlerts:  []string{"ScriptStateNotOK", "ScriptStateNotOK", "ScriptExecutionFailed", "ScriptResultStale"},
+		},
+		{
+			name: "probe and disabled scripts",
+			scripts: []config.Script{
+				{Name: "http", Path: "/bin/check_http", OutputType: "exit_code", Probe: true},
+				{Name: "dns", Path: "/bin/check_dns", OutputType: "exit_code", Alert: config.Alert{Disabled: true}},
+			},
+			interval:    time.Minute,
+			staleFactor: 3,
+		},
+		{
+			name:        "invalid schedule",
+			scripts:     []config.Script{{Name: "dns", Path: "/bin/check_dns", OutputType: "exit_code", Schedule: "not a schedule"}},
+			interval:    time.Minute,
+			staleFactor: 3,
+			wantErr:     "invalid schedule",
+		},
+		{name: "no interval", staleFactor: 3, wantErr: "interval must be > 0"},
+		{name: "stale factor below 1", interval: time.Minute, staleFactor: 0.5, wantErr: "stale factor must be >= 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := Generate(&config.Config{Scripts: tt.scripts}, tt.interval, tt.staleFactor)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var file RuleFile
+			if err := yaml.Unmarshal(content, &file); err != nil {
+				t.Fatalf("could not parse the rule file: %v", err)
+			}
+			if len(file.Groups) != 1 || file.Groups[0].Name != groupName {
+				t.Fatalf("expected a single %s group, got %+v", groupName, file.Groups)
+			}
+			alerts := []string{}
+			for _, rule := range file.Groups[0].Rules {
+				alerts = append(alerts, rule.Alert)
+			}
+			if strings.Join(alerts, ",") != strings.Join(tt.wantAlerts, ",") {
+				t.Errorf("expected alerts %v, got %v", tt.wantAlerts, alerts)
+			}
+		})
+	}
+}
+
+func TestScriptRulesSummaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		alert config.Alert
+		want  map[string]string
+	}{
+		{
+			name: "default summaries",
+			want: map[string]string{
+				"ScriptStateNotOK":      "Check dns{{ with $labels.target }} for {{ . }}{{ end }} is not OK (state {{ $value }})",
+				"ScriptExecutionFailed": "Execution of check dns{{ with $labels.target }} for {{ . }}{{ end }} failed",
+				"ScriptResultStale":     "Check dns hasn't succeeded for more than 3m0s",
+			},
+		},
+		{
+			name:  "summary applies to every alert",
+			alert: config.Alert{Summary: "DNS is failing"},
+			want: map[string]string{
+				"ScriptStateNotOK":      "DNS is failing",
+				"ScriptExecutionFailed": "DNS is failing",
+				"ScriptResultStale":     "DNS is failing",
+			},
+		},
+		{
+			name: "summaries override the summary",
+			alert: config.Alert{
+				Summary:   "DNS is failing",
+				Summaries: map[string]string{"ScriptStateNotOK": "DNS resolution is failing (state {{ $value }})"},
+			},
+			want: map[string]string{
+				"ScriptStateNotOK":      "DNS resolution is failing (state {{ $value }})",
+				"ScriptExecutionFailed": "DNS is failing",
+				"ScriptResultStale":     "DNS is failing",
+			},
+		},
+		{
+			name:  "summaries without a summary",
+			alert: config.Alert{Summaries: map[string]string{"ScriptResultStale": "DNS check is stale"}},
+			want: map[string]string{
+				"ScriptStateNotOK":      "Check dns{{ with $labels.target }} for {{ . }}{{ end }} is not OK (state {{ $value }})",
+				"ScriptExecutionFailed": "Execution of check dns{{ with $labels.target }} for {{ . }}{{ end }} failed",
+				"ScriptResultStale":     "DNS check is stale",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := config.Script{Name: "dns", Path: "/bin/check_dns", OutputType: "exit_code", Alert: tt.alert}
+			rules, err := scriptRules(script, "n2p", time.Minute, 3)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("expected %d rules, got %d", len(tt.want), len(rules))
+			}
+			for _, rule := range rules {
+				if got := rule.Annotations["summary"]; got != tt.want[rule.Alert] {
+					t.Errorf("expected summary %q for %s, got %q", tt.want[rule.Alert], rule.Alert, got)
+				}
+			}
+		})
+	}
+}
+
+func TestScriptRulesSettings(t *testing.T) {
+	script := config.Script{
+		Name:        "dns",
+		Path:        "/bin/check_dns",
+		OutputType:  "exit_code",
+		CheckPeriod: "workhours",
+		Alert:       config.Alert{For: "10m", Severity: "critical", RunbookURL: "https://runbooks.example.com/dns"},
+	}
+	rules, err := scriptRules(script, "n2p", time.Minute, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantExprs := map[string]string{
+		"ScriptStateNotOK":      `n2p_check_dns{script="/bin/check_dns"} != 0`,
+		"ScriptExecutionFailed": `n2p_script_last_run_success{script="/bin/check_dns"} == 0`,
+		"ScriptResultStale":     `(time() * 1000 - n2p_lastrun{script="/bin/check_dns"} > 180000) and on(script) n2p_script_in_active_period{script="/bin/check_dns"} == 1`,
+	}
+	for _, rule := range rules {
+		if rule.Expr != wantExprs[rule.Alert] {
+			t.Errorf("expected expression %q for %s, got %q", wantExprs[rule.Alert], rule.Alert, rule.Expr)
+		}
+		if rule.For != "10m" || rule.Labels["severity"] != "critical" || rule.Annotations["runbook_url"] != "https://runbooks.example.com/dns" {
+			t.Errorf("expected the alert settings on %s, got %+v", rule.Alert, rule)
+		}
+	}
+}
+
+func TestStaleAfter(t *testing.T) {
+	tests := []struct {
+		name        string
+		schedule    string
+		interval    time.Duration
+		staleFactor float64
+		want        time.Duration
+	}{
+		{name: "interval", interval: time.Minute, staleFactor: 3, want: 3 * time.Minute},
+		{name: "fractional factor", interval: time.Minute, staleFactor: 1.5, want: 90 * time.Second},
+		{name: "schedule longer than the interval", schedule: "*/30 * * * *", interval: time.Minute, staleFactor: 3, want: 90 * time.Minute},
+		{name: "schedule shorter than the interval", schedule: "* * * * *", interval: 5 * time.Minute, staleFactor: 2, want: 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := staleAfter(config.Script{Path: "/bin/check", Schedule: tt.schedule}, tt.interval, tt.staleFactor)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package sink
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// WriteStats holds the outcome of the writes done to a single sink
+type WriteStats struct {
+	LastSuccess  bool          `json:"last_success"`
+	LastDuration time.Duration `json:"last_duration"`
+	Successes    int           `json:"successes"`
+	Failures     int           `json:"failures"`
+}
+
+// Fanout writes the series to several sinks concurrently.  Each sink is handled on
+// its own so a failing or slow one doesn't prevent the others from being written, and
+// the outcome of each write is exposed as series in the following write.
+type Fanout struct {
+	sinks []Sink
+	mu    sync.Mutex
+	stats map[string]*WriteStats
+}
+
+// NewFanout returns a new instance of Fanout writing to the specified sinks
+func NewFanout(sinks []Sink) *Fanout {
+	return &Fanout{
+		sinks: sinks,
+		stats: map[string]*WriteStats{},
+	}
+}
+
+// Sinks returns the sinks written to
+func (f *Fanout) Sinks() []Sink {
+	return f.sinks
+}
+
+// Stats returns the outcome of the writes done to each sink, so it can be restored with
+// RestoreStats by the next execution when the executor isn't running as a daemon
+func (f *Fanout) Stats() map[string]WriteStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := make(map[string]WriteStats, len(f.stats))
+	for name, st := range f.stats {
+		stats[name] = *st
+	}
+	return stats
+}
+
+// RestoreStats sets the outcome of the previous writes done to the sinks, ignoring the
+// ones of sinks which aren't written to anymore
+func (f *Fanout) RestoreStats(stats map[string]WriteStats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range f.sinks {
+		if st, ok := stats[s.Name()]; ok {
+			f.stats[s.Name()] = &st
+		}
+	}
+}
+
+// Name returns the name of the sink
+func (f *Fanout) Name() string {
+	names := make([]string, len(f.sinks))
+	for i, s := range f.sinks {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Write sends the metrics, along with the series describing the previous writes, to
+// every sink.  The returned error is the last one encountered, if any.
+func (f *Fanout) Write(metrics []lib.Metric) error {
+	metrics = append(append([]lib.Metric{}, metrics...), f.Metrics()...)
+
+	var wg sync.WaitGroup
+	var lastErr error
+	for _, s := range f.sinks {
+		wg.Add(1)
+		go func(s Sink) {
+			defer wg.Done()
+			start := time.Now()
+			err := s.Write(metrics)
+			duration := time.Since(start)
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			st, ok := f.stats[s.Name()]
+			if !ok {
+				st = &WriteStats{}
+				f.stats[s.Name()] = st
+			}
+			st.LastDuration = duration
+			st.LastSuccess = err == nil
+			if err != nil {
+				log.Errorf("Could not write series to output %s: %v", s.Name(), err)
+				st.Failures++
+				lastErr = err
+				return
+			}
+			log.Debugf("Wrote %d series to output %s in %v", len(metrics), s.Name(), duration)
+			st.Successes++
+		}(s)
+	}
+	wg.Wait()
+
+	return lastErr
+}
+
+// Metrics returns the series describing the outcome of the previous write to each sink
+func (f *Fanout) Metrics() []lib.Metric {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	series := []lib.Metric{}
+	for _, s := range f.sinks {
+		st, ok := f.stats[s.Name()]
+		if !ok {
+			continue
+		}
+		lastSuccess := 0.0
+		if st.LastSuccess {
+			lastSuccess = 1.0
+		}
+		series = append(series, lib.Metric{
+			Name:   "output_last_write_success",
+			Labels: map[string]string{"output": s.Name()},
+			Value:  lastSuccess,
+			Type:   "gauge",
+			Help:   "indicates if the series were successfully written to the output on the last write",
+		})
+		series = append(series, lib.Metric{
+			Name:   "output_last_write_duration_ms",
+			Labels: map[string]string{"output": s.Name()},
+			Value:  float64(st.LastDuration.Milliseconds()),
+			Type:   "gauge",
+			Help:   "indicates the number of milliseconds it has taken to write the series to the output",
+		})
+		series = append(series, lib.Metric{
+			Name:   "output_writes_total",
+			Labels: map[string]string{"output": s.Name(), "result": "success"},
+			Value:  float64(st.Successes),
+			Type:   "counter",
+			Help:   "total number of writes to the output, by result",
+		})
+		series = append(series, lib.Metric{
+			Name:   "output_writes_total",
+			Labels: map[string]string{"output": s.Name(), "result": "failure"},
+			Value:  float64(st.Failures),
+			Type:   "counter",
+			Help:   "total number of writes to the output, by result",
+		})
+	}
+	return series
+}
+
+// Close closes every sink
+func (f *Fanout) Close() error {
+	var lastErr error
+	for _, s := range f.sinks {
+		if err := s.Close(); err != nil {
+			log.Errorf("Could not close output %s: %v", s.Name(), err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
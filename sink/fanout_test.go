@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+// fakeSink records the metrics written to it, and fails the writes when err is set
+type fakeSink struct {
+	name    string
+	err     error
+	written [][]lib.Metric
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) Write(metrics []lib.Metric) error {
+	s.written = append(s.written, metrics)
+	return s.err
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+// seriesValue returns the value of the series with the name and output label
+func seriesValue(metrics []lib.Metric, name string, labels map[string]string) (float64, bool) {
+	for _, m := range metrics {
+		if m.Name != name {
+			continue
+		}
+		matches := true
+		for k, v := range labels {
+			matches = matches && m.Labels[k] == v
+		}
+		if matches {
+			return m.Value, true
+		}
+	}
+	return 0, false
+}
+
+func TestFanoutWriteStats(t *testing.T) {
+	ok := &fakeSink{name: "ok"}
+	failing := &fakeSink{name: "failing", err: errors.New("unreachable")}
+	f := NewFanout([]Sink{ok, failing})
+
+	if err := f.Write([]lib.Metric{{Name: "up", Value: 1}}); err == nil {
+		t.Error("Write() didn't return the error of the failing sink")
+	}
+	if len(ok.written[0]) != 1 {
+		t.Errorf("the first write holds %d series, want only the one written", len(ok.written[0]))
+	}
+
+	f.Write([]lib.Metric{{Name: "up", Value: 1}})
+	second := ok.written[1]
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"output_last_write_success", map[string]string{"output": "ok"}, 1},
+		{"output_last_write_success", map[string]string{"output": "failing"}, 0},
+		{"output_writes_total", map[string]string{"output": "ok", "result": "success"}, 1},
+		{"output_writes_total", map[string]string{"output": "ok", "result": "failure"}, 0},
+		{"output_writes_total", map[string]string{"output": "failing", "result": "failure"}, 1},
+	}
+	for _, tt := range tests {
+		if got, found := seriesValue(second, tt.name, tt.labels); !found || got != tt.want {
+			t.Errorf("%s%v = %v (found: %v), want %v", tt.name, tt.labels, got, found, tt.want)
+		}
+	}
+}
+
+func TestFanoutRestoreStats(t *testing.T) {
+	previous := NewFanout([]Sink{&fakeSink{name: "ok"}, &fakeSink{name: "removed"}})
+	previous.Write(nil)
+	previous.Write(nil)
+
+	// The stats of the outputs are carried over to the fan-out of the next execution
+	ok := &fakeSink{name: "ok"}
+	f := NewFanout([]Sink{ok, &fakeSink{name: "added"}})
+	f.RestoreStats(previous.Stats())
+	f.Write(nil)
+
+	if got, _ := seriesValue(ok.written[0], "output_writes_total", map[string]string{"output": "ok", "result": "success"}); got != 2 {
+		t.Errorf("got %v successful writes restored, want 2", got)
+	}
+	if _, found := seriesValue(ok.written[0], "output_writes_total", map[string]string{"output": "removed"}); found {
+		t.Error("the stats of an output which was removed were restored")
+	}
+	if got := f.Stats()["ok"].Successes; got != 3 {
+		t.Errorf("got %d successful writes, want 3", got)
+	}
+}
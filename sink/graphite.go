@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	graphiteInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_\-:]+`)
+	graphiteTagEscaper   = strings.NewReplacer(";", "_", "~", "_", " ", "_")
+)
+
+// GraphiteSink writes the series in the Graphite plaintext protocol over TCP.
+// Without a template, labels are sent as Graphite tags (name;tag=value). With a
+// template such as "servers.{host}.{name}", each dot-separated component is either
+// a literal, the metric name ({name}) or the value of a label ({label_name}).
+// Components referencing a label the metric doesn't have are dropped.
+type GraphiteSink struct {
+	name     string
+	address  string
+	template []string
+	tags     map[string]string
+	timeout  time.Duration
+}
+
+// NewGraphiteSink returns a new instance of GraphiteSink
+func NewGraphiteSink(out config.Output, timeout time.Duration) *GraphiteSink {
+	s := &GraphiteSink{
+		name:    out.Name,
+		address: out.Address,
+		tags:    out.Tags,
+		timeout: timeout,
+	}
+	if out.Template != "" {
+		s.template = strings.Split(out.Template, ".")
+	}
+	return s
+}
+
+// Name returns the name of the sink
+func (s *GraphiteSink) Name() string {
+	return s.name
+}
+
+// Write renders the metrics in the plaintext protocol and sends them over a new TCP connection
+func (s *GraphiteSink) Write(metrics []lib.Metric) error {
+	lines := s.render(metrics, time.Now())
+	if len(lines) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(s.timeout))
+
+	w := bufio.NewWriter(conn)
+	for _, line := range lines {
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Close releases the resources held by the sink
+func (s *GraphiteSink) Close() error {
+	return nil
+}
+
+func (s *GraphiteSink) render(metrics []lib.Metric, ts time.Time) []string {
+	lines := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			log.Debugf("Skipping metric %s with non-finite value for output %s", metric.Name, s.name)
+			continue
+		}
+		labels := mergeLabels(metric, s.tags)
+		var path string
+		if len(s.template) > 0 {
+			path = s.templatePath(metric, labels)
+		} else {
+			path = s.taggedPath(metric, labels)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %d", path, formatValue(metric.Value), ts.Unix()))
+	}
+	return lines
+}
+
+func (s *GraphiteSink) templatePath(metric lib.Metric, labels map[string]string) string {
+	components := make([]string, 0, len(s.template))
+	for _, part := range s.template {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			components = append(components, sanitizeGraphitePath(part))
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
+		if key == "name" {
+			components = append(components, sanitizeGraphitePath(metric.FullName()))
+		} else if v, ok := labels[key]; ok && v != "" {
+			components = append(components, sanitizeGraphitePath(v))
+		}
+	}
+	return strings.Join(components, ".")
+}
+
+func (s *GraphiteSink) taggedPath(metric lib.Metric, labels map[string]string) string {
+	path := sanitizeGraphitePath(metric.FullName())
+	for _, k := range sortedKeys(labels) {
+		if labels[k] == "" {
+			continue
+		}
+		path += fmt.Sprintf(";%s=%s", graphiteTagEscaper.Replace(k), graphiteTagEscaper.Replace(labels[k]))
+	}
+	return path
+}
+
+func sanitizeGraphitePath(component string) string {
+	return graphiteInvalidChars.ReplaceAllString(component, "_")
+}
@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func TestGraphiteRender(t *testing.T) {
+	ts := time.Unix(1600000000, 0)
+	tests := []struct {
+		name     string
+		template string
+		tags     map[string]string
+		metrics  []lib.Metric
+		want     []string
+	}{
+		{
+			name:    "tagged",
+			tags:    map[string]string{"env": "prod"},
+			metrics: []lib.Metric{{Name: "check_load", Labels: map[string]string{"host": "web 1", "script": "a;b"}, Value: 0.5}},
+			want:    []string{"n2p_script_exec_check_load;env=prod;host=web_1;script=a_b 0.5 1600000000"},
+		},
+		{
+			name:     "template",
+			template: "servers.{host}.{name}",
+			metrics:  []lib.Metric{{Name: "check_load", Labels: map[string]string{"host": "web.1"}, Value: 2}},
+			want:     []string{"servers.web_1.n2p_script_exec_check_load 2 1600000000"},
+		},
+		{
+			name:     "template with a missing label",
+			template: "servers.{host}.{name}",
+			metrics:  []lib.Metric{{Name: "check_load", Value: 2}},
+			want:     []string{"servers.n2p_script_exec_check_load 2 1600000000"},
+		},
+		{
+			name:     "template falls back to the output tags",
+			template: "{env}.{name}",
+			tags:     map[string]string{"env": "prod"},
+			metrics:  []lib.Metric{{Name: "up", Value: 1}},
+			want:     []string{"prod.n2p_script_exec_up 1 1600000000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewGraphiteSink(config.Output{Name: "graphite", Template: tt.template, Tags: tt.tags}, time.Second)
+			if got := s.render(tt.metrics, ts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
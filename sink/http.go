@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+// HTTPSink pushes the series, in the Prometheus text format, to an HTTP endpoint such
+// as the Pushgateway (e.g. http://pushgateway:9091/metrics/job/n2p/instance/host01)
+type HTTPSink struct {
+	name    string
+	address string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewHTTPSink returns a new instance of HTTPSink
+func NewHTTPSink(out config.Output, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		name:    out.Name,
+		address: out.Address,
+		headers: out.Headers,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Name returns the name of the sink
+func (s *HTTPSink) Name() string {
+	return s.name
+}
+
+// Write renders the metrics and posts them to the endpoint
+func (s *HTTPSink) Write(metrics []lib.Metric) error {
+	req, err := http.NewRequest(http.MethodPost, s.address, strings.NewReader(lib.GenerateSeries(metrics)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned status %d: %s", s.address, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close releases the resources held by the sink
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxUDPPayload is the maximum size of a single datagram sent to InfluxDB over UDP
+const maxUDPPayload = 1400
+
+var (
+	measurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+	tagEscaper         = strings.NewReplacer(",", "\\,", " ", "\\ ", "=", "\\=")
+)
+
+// InfluxDBSink writes the series in the InfluxDB line protocol over HTTP, UDP or to a file
+type InfluxDBSink struct {
+	name     string
+	protocol string
+	address  string
+	path     string
+	database string
+	tags     map[string]string
+	timeout  time.Duration
+	client   *http.Client
+}
+
+// NewInfluxDBSink returns a new instance of InfluxDBSink
+func NewInfluxDBSink(out config.Output, timeout time.Duration) *InfluxDBSink {
+	return &InfluxDBSink{
+		name:     out.Name,
+		protocol: out.Protocol,
+		address:  out.Address,
+		path:     out.Path,
+		database: out.Database,
+		tags:     out.Tags,
+		timeout:  timeout,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Name returns the name of the sink
+func (s *InfluxDBSink) Name() string {
+	return s.name
+}
+
+// Write renders the metrics as line protocol and sends them to the configured destination
+func (s *InfluxDBSink) Write(metrics []lib.Metric) error {
+	lines := s.render(metrics, time.Now())
+	if len(lines) == 0 {
+		return nil
+	}
+
+	switch s.protocol {
+	case "udp":
+		return s.writeUDP(lines)
+	case "file":
+		if !lib.WriteToFile(s.path, strings.Join(lines, "\n")+"\n") {
+			return fmt.Errorf("could not write series to %s", s.path)
+		}
+		return nil
+	}
+	return s.writeHTTP(lines)
+}
+
+// Close releases the resources held by the sink
+func (s *InfluxDBSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *InfluxDBSink) render(metrics []lib.Metric, ts time.Time) []string {
+	lines := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			log.Debugf("Skipping metric %s with non-finite value for output %s", metric.Name, s.name)
+			continue
+		}
+		var line strings.Builder
+		line.WriteString(measurementEscaper.Replace(metric.FullName()))
+		labels := mergeLabels(metric, s.tags)
+		for _, k := range sortedKeys(labels) {
+			if labels[k] == "" {
+				continue
+			}
+			fmt.Fprintf(&line, ",%s=%s", tagEscaper.Replace(k), tagEscaper.Replace(labels[k]))
+		}
+		fmt.Fprintf(&line, " value=%s %d", formatValue(metric.Value), ts.UnixNano())
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+func (s *InfluxDBSink) writeHTTP(lines []string) error {
+	u, err := url.Parse(strings.TrimSuffix(s.address, "/") + "/write")
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if s.database != "" {
+		q.Set("db", s.database)
+	}
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	resp, err := s.client.Post(u.String(), "text/plain; charset=utf-8", strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *InfluxDBSink) writeUDP(lines []string) error {
+	conn, err := net.DialTimeout("udp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Pack as many lines as possible in each datagram without exceeding the payload size
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > maxUDPPayload {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if buf.Len() > 0 {
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func TestInfluxDBRender(t *testing.T) {
+	ts := time.Unix(1600000000, 500)
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		metrics []lib.Metric
+		want    []string
+	}{
+		{
+			name:    "no labels",
+			metrics: []lib.Metric{{Name: "check_load", Value: 1.25}},
+			want:    []string{"n2p_script_exec_check_load value=1.25 1600000000000000500"},
+		},
+		{
+			name: "sorted and escaped labels",
+			metrics: []lib.Metric{{
+				Name:   "check_disk",
+				Labels: map[string]string{"mount": "/var/lib a,b", "host": "web=1", "empty": ""},
+				Value:  3,
+			}},
+			want: []string{`n2p_script_exec_check_disk,host=web\=1,mount=/var/lib\ a\,b value=3 1600000000000000500`},
+		},
+		{
+			name:    "tags don't override labels",
+			tags:    map[string]string{"env": "prod", "host": "default"},
+			metrics: []lib.Metric{{Name: "up", Labels: map[string]string{"host": "web1"}, Value: 1}},
+			want:    []string{"n2p_script_exec_up,env=prod,host=web1 value=1 1600000000000000500"},
+		},
+		{
+			name: "non-finite values are skipped",
+			metrics: []lib.Metric{
+				{Name: "nan", Value: math.NaN()},
+				{Name: "inf", Value: math.Inf(-1)},
+				{Name: "ok", Value: 0},
+			},
+			want: []string{"n2p_script_exec_ok value=0 1600000000000000500"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewInfluxDBSink(config.Output{Name: "influx", Tags: tt.tags}, time.Second)
+			if got := s.render(tt.metrics, ts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	"github.com/hartfordfive/n2p-script-executor/version"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	otlpServiceName = "n2p-script-executor"
+	// otlpTemporalityDelta and otlpTemporalityCumulative are the values of the
+	// AggregationTemporality enum
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+	// otlpHistogramMetric is the series exported as an OTLP histogram instead of a gauge
+	otlpHistogramMetric = "script_last_execution_time_ms"
+)
+
+// otlpHistogramBounds are the explicit bucket bounds, in milliseconds, of the execution time histogram
+var otlpHistogramBounds = []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// otlpHistogram accumulates the execution time observations of a single script
+type otlpHistogram struct {
+	labels  map[string]string
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+	buckets []uint64
+}
+
+func (h *otlpHistogram) observe(v float64) {
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+	i := sort.SearchFloat64s(otlpHistogramBounds, v)
+	h.buckets[i]++
+}
+
+// OTLPSink exports the series as OTLP metrics over HTTP/protobuf.  Counters are
+// exported as cumulative monotonic sums, the script execution time as a histogram and
+// every other series as a gauge.  The latest results are exported on every write.  In
+// daemon mode, they are re-exported every export interval so the collector keeps them
+// fresh between executions, and the histogram is cumulative.  Otherwise, the sink only
+// lives for a single execution, so the histogram is exported as a delta.
+type OTLPSink struct {
+	name      string
+	endpoint  string
+	headers   map[string]string
+	resource  map[string]string
+	client    *http.Client
+	startTime time.Time
+	// temporality is the aggregation temporality of the histogram
+	temporality uint64
+
+	mu         sync.Mutex
+	latest     []lib.Metric
+	lastExport time.Time
+	histograms map[string]*otlpHistogram
+
+	stopChan chan interface{}
+	wg       sync.WaitGroup
+}
+
+// NewOTLPSink returns a new instance of OTLPSink and starts its periodic export, unless the
+// export interval is 0 which is the case outside of daemon mode
+func NewOTLPSink(out config.Output, timeout time.Duration, exportInterval time.Duration) *OTLPSink {
+	resource := map[string]string{
+		"service.name": otlpServiceName,
+	}
+	if version.Version != "" {
+		resource["service.version"] = version.Version
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		resource["host.name"] = hostname
+	}
+	for k, v := range out.Tags {
+		resource[k] = v
+	}
+
+	s := &OTLPSink{
+		name:        out.Name,
+		endpoint:    otlpEndpoint(out.Address),
+		headers:     out.Headers,
+		resource:    resource,
+		client:      &http.Client{Timeout: timeout},
+		startTime:   time.Now(),
+		temporality: otlpTemporalityCumulative,
+		histograms:  map[string]*otlpHistogram{},
+		stopChan:    make(chan interface{}),
+	}
+	if exportInterval == 0 {
+		s.temporality = otlpTemporalityDelta
+		return s
+	}
+	s.wg.Add(1)
+	go s.exportPeriodically(exportInterval)
+	return s
+}
+
+// otlpEndpoint appends the default metrics path when the address is only a base URL
+func otlpEndpoint(address string) string {
+	u, err := url.Parse(address)
+	if err != nil || (u.Path != "" && u.Path != "/") {
+		return address
+	}
+	return strings.TrimSuffix(address, "/") + "/v1/metrics"
+}
+
+// Name returns the name of the sink
+func (s *OTLPSink) Name() string {
+	return s.name
+}
+
+// Write records the metrics as the latest results and exports them.  Only the execution
+// times of the scripts executed in this run are observed, not the carried ones of the
+// scripts which weren't due.  The histograms of the scripts which are no longer part of
+// the results are dropped.
+func (s *OTLPSink) Write(metrics []lib.Metric) error {
+	s.mu.Lock()
+	s.latest = metrics
+	current := map[string]bool{}
+	for _, metric := range metrics {
+		if metric.Name != otlpHistogramMetric {
+			continue
+		}
+		key := labelsKey(metric.Labels)
+		current[key] = true
+		if metric.Carried {
+			continue
+		}
+		h, ok := s.histograms[key]
+		if !ok {
+			h = &otlpHistogram{labels: metric.Labels, buckets: make([]uint64, len(otlpHistogramBounds)+1)}
+			s.histograms[key] = h
+		}
+		h.observe(metric.Value)
+	}
+	for key := range s.histograms {
+		if !current[key] {
+			delete(s.histograms, key)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.export()
+}
+
+// Close stops the periodic export
+func (s *OTLPSink) Close() error {
+	close(s.stopChan)
+	s.wg.Wait()
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *OTLPSink) exportPeriodically(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			due := s.latest != nil && time.Since(s.lastExport) >= interval
+			s.mu.Unlock()
+			if !due {
+				continue
+			}
+			if err := s.export(); err != nil {
+				log.Errorf("Could not export series to output %s: %v", s.name, err)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+func (s *OTLPSink) export() error {
+	s.mu.Lock()
+	now := time.Now()
+	body := s.encode(now)
+	s.lastExport = now
+	s.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("OTLP collector returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// encode builds the ExportMetricsServiceRequest from the latest results.  Must be
+// called with the lock held.
+func (s *OTLPSink) encode(now time.Time) []byte {
+	start := uint64(s.startTime.UnixNano())
+	ts := uint64(now.UnixNano())
+
+	// Data points of the same series name are grouped under a single OTLP metric
+	grouped := map[string][]lib.Metric{}
+	names := []string{}
+	for _, metric := range s.latest {
+		if metric.Name == otlpHistogramMetric {
+			continue
+		}
+		if _, ok := grouped[metric.Name]; !ok {
+			names = append(names, metric.Name)
+		}
+		grouped[metric.Name] = append(grouped[metric.Name], metric)
+	}
+
+	req := &protoBuffer{}
+	req.messageField(1, func(rm *protoBuffer) { // ResourceMetrics
+		rm.messageField(1, func(r *protoBuffer) { // Resource
+			encodeAttributes(r, 1, s.resource)
+		})
+		rm.messageField(2, func(sm *protoBuffer) { // ScopeMetrics
+			sm.messageField(1, func(scope *protoBuffer) {
+				scope.stringField(1, otlpServiceName)
+				scope.stringField(2, version.Version)
+			})
+			for _, name := range names {
+				metrics := grouped[name]
+				sm.messageField(2, func(m *protoBuffer) {
+					m.stringField(1, metrics[0].FullName())
+					m.stringField(2, metrics[0].Help)
+					if metrics[0].Type == "counter" {
+						m.messageField(7, func(sum *protoBuffer) {
+							encodeNumberDataPoints(sum, metrics, start, ts)
+							sum.uint64Field(2, otlpTemporalityCumulative)
+							sum.boolField(3, true)
+						})
+						return
+					}
+					m.messageField(5, func(gauge *protoBuffer) {
+						encodeNumberDataPoints(gauge, metrics, start, ts)
+					})
+				})
+			}
+			if len(s.histograms) > 0 {
+				sm.messageField(2, func(m *protoBuffer) {
+					m.stringField(1, lib.Metric{Name: otlpHistogramMetric}.FullName())
+					m.stringField(2, "distribution of the number of milliseconds it has taken to execute the script")
+					m.stringField(3, "ms")
+					m.messageField(9, func(hist *protoBuffer) {
+						for _, key := range sortedHistogramKeys(s.histograms) {
+							h := s.histograms[key]
+							hist.messageField(1, func(dp *protoBuffer) {
+								dp.fixed64Field(2, start)
+								dp.fixed64Field(3, ts)
+								dp.fixed64Field(4, h.count)
+								dp.doubleField(5, h.sum)
+								dp.packedFixed64Field(6, h.buckets)
+								dp.packedDoubleField(7, otlpHistogramBounds)
+								encodeAttributes(dp, 9, h.labels)
+								dp.doubleField(11, h.min)
+								dp.doubleField(12, h.max)
+							})
+						}
+						hist.uint64Field(2, s.temporality)
+					})
+				})
+			}
+		})
+	})
+	return req.Bytes()
+}
+
+func encodeNumberDataPoints(p *protoBuffer, metrics []lib.Metric, start uint64, ts uint64) {
+	for _, metric := range metrics {
+		p.messageField(1, func(dp *protoBuffer) {
+			dp.fixed64Field(2, start)
+			dp.fixed64Field(3, ts)
+			dp.doubleField(4, metric.Value)
+			encodeAttributes(dp, 7, metric.Labels)
+		})
+	}
+}
+
+// encodeAttributes encodes the map as repeated KeyValue with string values
+func encodeAttributes(p *protoBuffer, field int, attrs map[string]string) {
+	for _, k := range sortedKeys(attrs) {
+		v := attrs[k]
+		p.messageField(field, func(kv *protoBuffer) {
+			kv.stringField(1, k)
+			kv.messageField(2, func(value *protoBuffer) {
+				value.stringField(1, v)
+			})
+		})
+	}
+}
+
+// labelsKey returns a stable identifier for the set of labels
+func labelsKey(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for _, k := range sortedKeys(labels) {
+		parts = append(parts, k+"="+labels[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedHistogramKeys(m map[string]*otlpHistogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func TestOTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/metrics"},
+		{"http://localhost:4318/", "http://localhost:4318/v1/metrics"},
+		{"http://localhost:4318/custom/path", "http://localhost:4318/custom/path"},
+	}
+	for _, tt := range tests {
+		if got := otlpEndpoint(tt.address); got != tt.want {
+			t.Errorf("otlpEndpoint(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+// otlpMetrics returns the name and the fields of the data of each metric in the request
+func otlpMetrics(t *testing.T, body []byte) map[string][]protoField {
+	metrics := map[string][]protoField{}
+	for _, rm := range protoFields(decodeProto(t, body), 1) {
+		for _, sm := range protoFields(decodeProto(t, rm.bytes), 2) {
+			for _, m := range protoFields(decodeProto(t, sm.bytes), 2) {
+				fields := decodeProto(t, m.bytes)
+				name := string(protoFields(fields, 1)[0].bytes)
+				metrics[name] = fields
+			}
+		}
+	}
+	return metrics
+}
+
+func TestOTLPWrite(t *testing.T) {
+	tests := []struct {
+		name            string
+		exportInterval  time.Duration
+		wantTemporality uint64
+	}{
+		{"daemon mode", time.Hour, otlpTemporalityCumulative},
+		{"single execution", 0, otlpTemporalityDelta},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodies := make(chan []byte, 10)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+					t.Errorf("unexpected content type %q", ct)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies <- body
+			}))
+			defer server.Close()
+
+			s := NewOTLPSink(config.Output{Name: "otel", Address: server.URL}, time.Second, tt.exportInterval)
+			defer s.Close()
+
+			first := []lib.Metric{
+				{Name: "check_load", Value: 1, Type: "gauge", Labels: map[string]string{"script": "a"}},
+				{Name: "lastrun", Value: 1600000000000, Type: "counter", Labels: map[string]string{"script": "a"}},
+				{Name: otlpHistogramMetric, Value: 120, Labels: map[string]string{"script": "a"}},
+				{Name: otlpHistogramMetric, Value: 30, Labels: map[string]string{"script": "b"}},
+			}
+			if err := s.Write(first); err != nil {
+				t.Fatalf("Write() returned %v", err)
+			}
+			metrics := otlpMetrics(t, <-bodies)
+			if len(protoFields(metrics["n2p_script_exec_check_load"], 5)) != 1 {
+				t.Errorf("check_load isn't exported as a gauge")
+			}
+			if len(protoFields(metrics["n2p_script_exec_lastrun"], 7)) != 1 {
+				t.Errorf("lastrun isn't exported as a sum")
+			}
+			hist := protoFields(metrics["n2p_script_exec_"+otlpHistogramMetric], 9)
+			if len(hist) != 1 {
+				t.Fatalf("%s isn't exported as a histogram", otlpHistogramMetric)
+			}
+			histFields := decodeProto(t, hist[0].bytes)
+			if points := protoFields(histFields, 1); len(points) != 2 {
+				t.Errorf("got %d histogram data points, want 2", len(points))
+			}
+			if temporality := protoFields(histFields, 2); len(temporality) != 1 || temporality[0].value != tt.wantTemporality {
+				t.Errorf("got temporality %v, want %d", temporality, tt.wantTemporality)
+			}
+
+			// The histogram of script b is dropped once it's no longer in the results
+			if err := s.Write(first[:3]); err != nil {
+				t.Fatalf("Write() returned %v", err)
+			}
+			<-bodies
+			if len(s.histograms) != 1 || s.histograms["script=a"].count != 2 {
+				t.Errorf("unexpected histograms after the second write: %+v", s.histograms)
+			}
+		})
+	}
+}
+
+func TestOTLPWriteSkipsCarriedExecutionTimes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// In daemon mode, the execution time of script b, which isn't due in the later runs,
+	// is carried from its last execution
+	s := NewOTLPSink(config.Output{Name: "otel", Address: server.URL}, time.Second, time.Hour)
+	defer s.Close()
+	runs := [][]lib.Metric{
+		{
+			{Name: otlpHistogramMetric, Value: 120, Labels: map[string]string{"script": "a"}},
+			{Name: otlpHistogramMetric, Value: 30, Labels: map[string]string{"script": "b"}},
+		},
+		{
+			{Name: otlpHistogramMetric, Value: 100, Labels: map[string]string{"script": "a"}},
+			{Name: otlpHistogramMetric, Value: 30, Labels: map[string]string{"script": "b"}, Carried: true},
+		},
+		{
+			{Name: otlpHistogramMetric, Value: 110, Labels: map[string]string{"script": "a"}},
+			{Name: otlpHistogramMetric, Value: 30, Labels: map[string]string{"script": "b"}, Carried: true},
+		},
+	}
+	for _, run := range runs {
+		if err := s.Write(run); err != nil {
+			t.Fatalf("Write() returned %v", err)
+		}
+	}
+
+	want := map[string]struct {
+		count uint64
+		sum   float64
+	}{
+		"script=a": {3, 330},
+		"script=b": {1, 30},
+	}
+	if len(s.histograms) != len(want) {
+		t.Fatalf("got histograms %+v, want %v", s.histograms, want)
+	}
+	for key, w := range want {
+		if h := s.histograms[key]; h == nil || h.count != w.count || h.sum != w.sum {
+			t.Errorf("histogram %s = %+v, want count %d and sum %v", key, h, w.count, w.sum)
+		}
+	}
+}
+
+func TestOTLPHistogramObserve(t *testing.T) {
+	h := &otlpHistogram{buckets: make([]uint64, len(otlpHistogramBounds)+1)}
+	for _, v := range []float64{5, 10, 11, 70000} {
+		h.observe(v)
+	}
+	if h.count != 4 || h.sum != 70026 || h.min != 5 || h.max != 70000 {
+		t.Errorf("unexpected histogram %+v", h)
+	}
+	// Bucket i holds the values <= bound i, the last one the values above every bound
+	want := map[int]uint64{0: 2, 1: 1, len(otlpHistogramBounds): 1}
+	for i, count := range h.buckets {
+		if count != want[i] {
+			t.Errorf("bucket %d holds %d values, want %d", i, count, want[i])
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protobuf wire types used by the OTLP encoding
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// protoBuffer is a minimal protobuf encoder covering the field types required to
+// build OTLP export requests, which avoids pulling in the full protobuf runtime
+type protoBuffer struct {
+	buf []byte
+}
+
+func (p *protoBuffer) Bytes() []byte {
+	return p.buf
+}
+
+func (p *protoBuffer) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	p.buf = append(p.buf, b[:n]...)
+}
+
+func (p *protoBuffer) tag(field int, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uint64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, wireVarint)
+	p.varint(v)
+}
+
+func (p *protoBuffer) boolField(field int, v bool) {
+	if v {
+		p.uint64Field(field, 1)
+	}
+}
+
+func (p *protoBuffer) fixed64Field(field int, v uint64) {
+	p.tag(field, wireFixed64)
+	p.buf = appendFixed64(p.buf, v)
+}
+
+func (p *protoBuffer) doubleField(field int, v float64) {
+	p.fixed64Field(field, math.Float64bits(v))
+}
+
+func (p *protoBuffer) bytesField(field int, v []byte) {
+	p.tag(field, wireBytes)
+	p.varint(uint64(len(v)))
+	p.buf = append(p.buf, v...)
+}
+
+func (p *protoBuffer) stringField(field int, v string) {
+	if v == "" {
+		return
+	}
+	p.bytesField(field, []byte(v))
+}
+
+// messageField encodes the message built by fn as an embedded field
+func (p *protoBuffer) messageField(field int, fn func(m *protoBuffer)) {
+	m := &protoBuffer{}
+	fn(m)
+	p.bytesField(field, m.buf)
+}
+
+func (p *protoBuffer) packedFixed64Field(field int, values []uint64) {
+	packed := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		packed = appendFixed64(packed, v)
+	}
+	p.bytesField(field, packed)
+}
+
+func (p *protoBuffer) packedDoubleField(field int, values []float64) {
+	bits := make([]uint64, len(values))
+	for i, v := range values {
+		bits[i] = math.Float64bits(v)
+	}
+	p.packedFixed64Field(field, bits)
+}
+
+func appendFixed64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestProtoBufferFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode func(p *protoBuffer)
+		want   []byte
+	}{
+		{"varint", func(p *protoBuffer) { p.uint64Field(1, 150) }, []byte{0x08, 0x96, 0x01}},
+		{"zero varint is omitted", func(p *protoBuffer) { p.uint64Field(1, 0) }, nil},
+		{"large field number", func(p *protoBuffer) { p.uint64Field(16, 1) }, []byte{0x80, 0x01, 0x01}},
+		{"true", func(p *protoBuffer) { p.boolField(3, true) }, []byte{0x18, 0x01}},
+		{"false is omitted", func(p *protoBuffer) { p.boolField(3, false) }, nil},
+		{"string", func(p *protoBuffer) { p.stringField(2, "testing") }, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{"empty string is omitted", func(p *protoBuffer) { p.stringField(2, "") }, nil},
+		{"fixed64", func(p *protoBuffer) { p.fixed64Field(2, 1) }, []byte{0x11, 1, 0, 0, 0, 0, 0, 0, 0}},
+		{"zero fixed64 is kept", func(p *protoBuffer) { p.fixed64Field(2, 0) }, []byte{0x11, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"double", func(p *protoBuffer) { p.doubleField(5, 1) }, []byte{0x29, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{
+			"embedded message",
+			func(p *protoBuffer) { p.messageField(1, func(m *protoBuffer) { m.stringField(1, "a") }) },
+			[]byte{0x0a, 0x03, 0x0a, 0x01, 'a'},
+		},
+		{
+			"empty embedded message is kept",
+			func(p *protoBuffer) { p.messageField(1, func(m *protoBuffer) {}) },
+			[]byte{0x0a, 0x00},
+		},
+		{
+			"packed fixed64",
+			func(p *protoBuffer) { p.packedFixed64Field(6, []uint64{1, 2}) },
+			[]byte{0x32, 0x10, 1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			"packed double",
+			func(p *protoBuffer) { p.packedDoubleField(7, []float64{2}) },
+			[]byte{0x3a, 0x08, 0, 0, 0, 0, 0, 0, 0, 0x40},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &protoBuffer{}
+			tt.encode(p)
+			if !bytes.Equal(p.Bytes(), tt.want) {
+				t.Errorf("got % x, want % x", p.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+// protoField is a field decoded from a protobuf message by decodeProto
+type protoField struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+// decodeProto decodes the fields of a message made of the wire types written by protoBuffer
+func decodeProto(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("invalid tag in % x", b)
+		}
+		b = b[n:]
+		f := protoField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// protoFields returns the fields of the message with the number
+func protoFields(fields []protoField, num int) []protoField {
+	var matching []protoField
+	for _, f := range fields {
+		if f.num == num {
+			matching = append(matching, f)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+// Sink is implemented by every destination the resulting series can be written to
+type Sink interface {
+	Name() string
+	Write(metrics []lib.Metric) error
+	Close() error
+}
+
+// defaultExportInterval is the interval the OTLP output re-exports the latest results at
+// in daemon mode, when none is configured
+const defaultExportInterval = 60 * time.Second
+
+// New returns the sink matching the type of the specified output.  The periodic export
+// of the OTLP output only applies in daemon mode, as the sinks are created anew on every
+// execution otherwise.
+func New(out config.Output, scripts []config.Script, daemon bool) (Sink, error) {
+	timeout, _ := time.ParseDuration(out.Timeout)
+
+	switch out.Type {
+	case "textfile":
+		return NewTextfileSink(out, scripts)
+	case "stdout":
+		return NewStdoutSink(out), nil
+	case "http":
+		return NewHTTPSink(out, timeout), nil
+	case "influxdb":
+		return NewInfluxDBSink(out, timeout), nil
+	case "graphite":
+		return NewGraphiteSink(out, timeout), nil
+	case "statsd":
+		return NewStatsDSink(out, timeout), nil
+	case "otlp":
+		if !daemon {
+			if out.ExportInterval != "" {
+				return nil, fmt.Errorf("export_interval of output '%s' only applies in daemon mode", out.Name)
+			}
+			return NewOTLPSink(out, timeout, 0), nil
+		}
+		exportInterval := defaultExportInterval
+		if out.ExportInterval != "" {
+			exportInterval, _ = time.ParseDuration(out.ExportInterval)
+		}
+		return NewOTLPSink(out, timeout, exportInterval), nil
+	}
+	return nil, fmt.Errorf("Unsupported output type: %s", out.Type)
+}
+
+// mergeLabels returns the metric labels with the static output tags added, without
+// overriding labels already present on the metric
+func mergeLabels(metric lib.Metric, tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(metric.Labels)+len(tags))
+	for k, v := range tags {
+		merged[k] = v
+	}
+	for k, v := range metric.Labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// sortedKeys returns the keys of the map in lexical order so the rendered series are stable
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// maxExactInt is the largest magnitude below which every integer is exactly representable
+// as a float64
+const maxExactInt = 1 << 53
+
+// formatValue renders the value as an int when possible, like the textfile output does,
+// and otherwise with the shortest representation which keeps its full precision
+func formatValue(val float64) string {
+	if lib.ValueCanBeInt(val) && math.Abs(val) < maxExactInt {
+		return strconv.FormatInt(int64(val), 10)
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
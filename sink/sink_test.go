@@ -0,0 +1,29 @@
+package sink
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		val  float64
+		want string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{-3, "-3"},
+		{1.5, "1.5"},
+		{0.1, "0.1"},
+		{1e-7, "1e-07"},
+		{123456.789012345, "123456.789012345"},
+		{1 << 53, "9.007199254740992e+15"},
+		{1e300, "1e+300"},
+		{math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.val); got != tt.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tt.val, got, tt.want)
+		}
+	}
+}
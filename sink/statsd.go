@@ -0,0 +1,136 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	statsdInvalidChars = regexp.MustCompile(`[:|@#\s]+`)
+	statsdTagEscaper   = strings.NewReplacer(",", "_", "|", "_", "#", "_", " ", "_")
+	// statsdGaugeSeries are the cumulative series of the executor itself, which are sent as
+	// gauges since StatsD adds up the values of counters
+	statsdGaugeSeries = map[string]bool{
+		"lastrun":               true,
+		"build_info":            true,
+		"script_failures_total": true,
+		"output_writes_total":   true,
+	}
+)
+
+// StatsDSink writes each series as a StatsD gauge or counter over UDP, with the labels
+// carried as DogStatsD tags (name:value|g|#label:value).  Series of the scripts with a
+// "counter" type are sent as-is with the counter type, so scripts declared as counters
+// should report the increment since their last execution.  The cumulative series of the
+// executor are sent as gauges.
+type StatsDSink struct {
+	name    string
+	address string
+	// prefix is the prefix of the output, the series prefix is used when it's empty
+	prefix  string
+	tags    map[string]string
+	timeout time.Duration
+}
+
+// NewStatsDSink returns a new instance of StatsDSink
+func NewStatsDSink(out config.Output, timeout time.Duration) *StatsDSink {
+	return &StatsDSink{
+		name:    out.Name,
+		address: out.Address,
+		prefix:  out.Prefix,
+		tags:    out.Tags,
+		timeout: timeout,
+	}
+}
+
+// Name returns the name of the sink
+func (s *StatsDSink) Name() string {
+	return s.name
+}
+
+// Write sends the metrics to the StatsD agent, packing as many as possible in each datagram
+func (s *StatsDSink) Write(metrics []lib.Metric) error {
+	lines := s.render(metrics)
+	if len(lines) == 0 {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("udp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > maxUDPPayload {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// Close releases the resources held by the sink
+func (s *StatsDSink) Close() error {
+	return nil
+}
+
+func (s *StatsDSink) render(metrics []lib.Metric) []string {
+	prefix := s.prefix
+	if prefix == "" {
+		prefix = lib.GetSeriesPrefix()
+	}
+	lines := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			log.Debugf("Skipping metric %s with non-finite value for output %s", metric.Name, s.name)
+			continue
+		}
+
+		metricType := "g"
+		if metric.Type == "counter" && !statsdGaugeSeries[metric.Name] {
+			metricType = "c"
+		}
+
+		name := statsdInvalidChars.ReplaceAllString(prefix+"."+metric.Name, "_")
+		line := fmt.Sprintf("%s:%s|%s", name, formatValue(metric.Value), metricType)
+
+		labels := mergeLabels(metric, s.tags)
+		tags := make([]string, 0, len(labels))
+		for _, k := range sortedKeys(labels) {
+			if labels[k] == "" {
+				continue
+			}
+			tags = append(tags, fmt.Sprintf("%s:%s", statsdTagEscaper.Replace(k), statsdTagEscaper.Replace(labels[k])))
+		}
+		tagSuffix := ""
+		if len(tags) > 0 {
+			tagSuffix = "|#" + strings.Join(tags, ",")
+		}
+
+		// A signed gauge value is applied as a delta by StatsD, so a negative gauge
+		// has to be reset to zero before being set
+		if metricType == "g" && metric.Value < 0 {
+			lines = append(lines, fmt.Sprintf("%s:0|g%s", name, tagSuffix))
+		}
+		lines = append(lines, line+tagSuffix)
+	}
+	return lines
+}
@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func TestStatsDRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		tags    map[string]string
+		metrics []lib.Metric
+		want    []string
+	}{
+		{
+			name:    "gauge with the series prefix",
+			metrics: []lib.Metric{{Name: "check_load", Value: 0.75, Type: "gauge"}},
+			want:    []string{"n2p_script_exec.check_load:0.75|g"},
+		},
+		{
+			name:    "counter",
+			prefix:  "nagios",
+			metrics: []lib.Metric{{Name: "errors_total", Value: 3, Type: "counter"}},
+			want:    []string{"nagios.errors_total:3|c"},
+		},
+		{
+			name:   "cumulative series of the executor are gauges",
+			prefix: "nagios",
+			metrics: []lib.Metric{
+				{Name: "lastrun", Value: 1589537621319, Type: "counter"},
+				{Name: "build_info", Value: 1, Type: "counter"},
+				{Name: "script_failures_total", Value: 4, Type: "counter", Source: "/bin/check"},
+				{Name: "output_writes_total", Value: 12, Type: "counter"},
+			},
+			want: []string{
+				"nagios.lastrun:1589537621319|g",
+				"nagios.build_info:1|g",
+				"nagios.script_failures_total:4|g",
+				"nagios.output_writes_total:12|g",
+			},
+		},
+		{
+			name:    "tags",
+			prefix:  "nagios",
+			tags:    map[string]string{"env": "prod"},
+			metrics: []lib.Metric{{Name: "up", Value: 1, Labels: map[string]string{"script": "a b|c", "empty": ""}}},
+			want:    []string{"nagios.up:1|g|#env:prod,script:a_b_c"},
+		},
+		{
+			name:    "negative gauge is reset first",
+			prefix:  "nagios",
+			metrics: []lib.Metric{{Name: "temperature", Value: -4, Type: "gauge"}},
+			want:    []string{"nagios.temperature:0|g", "nagios.temperature:-4|g"},
+		},
+		{
+			name:    "invalid characters and non-finite values",
+			prefix:  "my prefix",
+			metrics: []lib.Metric{{Name: "a:b", Value: 1}, {Name: "nan", Value: math.NaN()}},
+			want:    []string{"my_prefix.a_b:1|g"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStatsDSink(config.Output{Name: "statsd", Prefix: tt.prefix, Tags: tt.tags}, time.Second)
+			if got := s.render(tt.metrics); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsDRenderFollowsSeriesPrefix(t *testing.T) {
+	defer lib.SetSeriesPrefix(lib.GetSeriesPrefix())
+
+	s := NewStatsDSink(config.Output{Name: "statsd"}, time.Second)
+	lib.SetSeriesPrefix("reloaded")
+	want := []string{"reloaded.check_load:1|g"}
+	if got := s.render([]lib.Metric{{Name: "check_load", Value: 1}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("render() = %q, want %q", got, want)
+	}
+}
+
+func TestStatsDWritePacksDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := make([]lib.Metric, 100)
+	for i := range metrics {
+		metrics[i] = lib.Metric{Name: "check_with_a_rather_long_series_name", Value: float64(i)}
+	}
+	s := NewStatsDSink(config.Output{Name: "statsd", Address: conn.LocalAddr().String()}, time.Second)
+	if err := s.Write(metrics); err != nil {
+		t.Fatalf("Write() returned %v", err)
+	}
+
+	lines := 0
+	buf := make([]byte, 65536)
+	for lines < len(metrics) {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d lines, want %d: %v", lines, len(metrics), err)
+		}
+		if n > maxUDPPayload {
+			t.Errorf("received a datagram of %d bytes, more than %d", n, maxUDPPayload)
+		}
+		for _, b := range buf[:n] {
+			if b == '\n' {
+				lines++
+			}
+		}
+		lines++
+	}
+}
@@ -0,0 +1,37 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+// StdoutSink prints the series, in the Prometheus text format, to stdout
+type StdoutSink struct {
+	name string
+}
+
+// NewStdoutSink returns a new instance of StdoutSink
+func NewStdoutSink(out config.Output) *StdoutSink {
+	return &StdoutSink{
+		name: out.Name,
+	}
+}
+
+// Name returns the name of the sink
+func (s *StdoutSink) Name() string {
+	return s.name
+}
+
+// Write prints the rendered metrics to stdout
+func (s *StdoutSink) Write(metrics []lib.Metric) error {
+	_, err := fmt.Fprint(os.Stdout, lib.GenerateSeries(metrics))
+	return err
+}
+
+// Close releases the resources held by the sink
+func (s *StdoutSink) Close() error {
+	return nil
+}
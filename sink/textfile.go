@@ -0,0 +1,168 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// textfilePrefix is the prefix of every file written by a split textfile output
+	textfilePrefix = "n2p_"
+	// textfileExecutor is the file holding the series describing the executor itself
+	textfileExecutor = textfilePrefix + "executor.prom"
+	// defaultGroup is the group of the scripts that don't specify one
+	defaultGroup = "default"
+)
+
+var textfileInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_\-]+`)
+
+// TextfileSink atomically writes the series, in the Prometheus text format, to the file
+// read by the textfile collector module of the node_exporter.  When split by script or
+// group, one file per script or group is written in the directory instead, so that an
+// invalid series only causes its own file to be rejected.  Files previously written by
+// the output for scripts or groups that are no longer in the config are removed.
+type TextfileSink struct {
+	name      string
+	path      string
+	directory string
+	splitBy   string
+	mode      os.FileMode
+	uid       int
+	gid       int
+	// files maps the path of each script to the file its series are written to
+	files map[string]string
+}
+
+// NewTextfileSink returns a new instance of TextfileSink
+func NewTextfileSink(out config.Output, scripts []config.Script) (*TextfileSink, error) {
+	mode, _ := strconv.ParseUint(out.FileMode, 8, 32)
+	uid, gid, err := lib.LookupOwner(out.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner for output %s: %v", out.Name, err)
+	}
+
+	s := &TextfileSink{
+		name:      out.Name,
+		path:      out.Path,
+		directory: out.Directory,
+		splitBy:   out.SplitBy,
+		mode:      os.FileMode(mode),
+		uid:       uid,
+		gid:       gid,
+		files:     TextfileFiles(out, scripts),
+	}
+	return s, nil
+}
+
+// TextfileFiles returns the file the series of each script are written to by the textfile
+// output, by path of the script.  It's empty when the output isn't split.
+func TextfileFiles(out config.Output, scripts []config.Script) map[string]string {
+	files := map[string]string{}
+	for _, script := range scripts {
+		switch out.SplitBy {
+		case "script":
+			name := script.Name
+			if name == "" {
+				name = lib.GetScriptName(script.Path)
+			}
+			files[script.Path] = textfileName("script", name)
+		case "group":
+			group := script.Group
+			if group == "" {
+				group = defaultGroup
+			}
+			files[script.Path] = textfileName("group", group)
+		}
+	}
+	return files
+}
+
+func textfileName(kind string, name string) string {
+	return fmt.Sprintf("%s%s_%s.prom", textfilePrefix, kind, textfileInvalidChars.ReplaceAllString(name, "_"))
+}
+
+// Name returns the name of the sink
+func (s *TextfileSink) Name() string {
+	return s.name
+}
+
+// Write renders the metrics and atomically replaces the content of the file(s)
+func (s *TextfileSink) Write(metrics []lib.Metric) error {
+	if s.splitBy == "" {
+		return lib.AtomicWriteFile(s.path, lib.GenerateSeries(metrics), s.mode, s.uid, s.gid)
+	}
+
+	split := map[string][]lib.Metric{}
+	for _, metric := range metrics {
+		file, ok := s.files[metric.Source]
+		if !ok {
+			file = textfileExecutor
+		}
+		split[file] = append(split[file], metric)
+	}
+
+	var lastErr error
+	for file, fileMetrics := range split {
+		path := filepath.Join(s.directory, file)
+		if err := lib.AtomicWriteFile(path, lib.GenerateSeries(fileMetrics), s.mode, s.uid, s.gid); err != nil {
+			log.Errorf("Could not write series to %s: %v", path, err)
+			lastErr = err
+		}
+	}
+
+	s.removeOrphans(split)
+	return lastErr
+}
+
+// removeOrphans removes the files previously written by the sink which weren't part of
+// the last write, such as the ones of scripts removed from the config.  The files written
+// by the sink are listed in its manifest, so the files of other outputs or executors
+// sharing the directory are left alone.
+func (s *TextfileSink) removeOrphans(written map[string][]lib.Metric) {
+	manifest := filepath.Join(s.directory, textfileManifest(s.name))
+	content, err := ioutil.ReadFile(manifest)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Could not read the files previously written to %s: %v", s.directory, err)
+		return
+	}
+	for _, name := range strings.Split(string(content), "\n") {
+		if _, ok := written[name]; ok || !strings.HasPrefix(name, textfilePrefix) || name != filepath.Base(name) {
+			continue
+		}
+		path := filepath.Join(s.directory, name)
+		log.Infof("Removing orphaned file %s", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Could not remove orphaned file %s: %v", path, err)
+		}
+	}
+
+	names := make([]string, 0, len(written))
+	for name := range written {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := lib.AtomicWriteFile(manifest, strings.Join(names, "\n")+"\n", 0644, -1, -1); err != nil {
+		log.Errorf("Could not write the list of files written to %s: %v", s.directory, err)
+	}
+}
+
+// textfileManifest returns the name of the file listing the files written by the output.
+// It's hidden and doesn't end with .prom, so it's ignored by the textfile collector.
+func textfileManifest(output string) string {
+	return "." + textfilePrefix + textfileInvalidChars.ReplaceAllString(output, "_") + ".files"
+}
+
+// Close releases the resources held by the sink
+func (s *TextfileSink) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hartfordfive/n2p-script-executor/config"
+	"github.com/hartfordfive/n2p-script-executor/lib"
+)
+
+func promFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = filepath.Base(m)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestTextfileSplitRemovesOnlyItsOrphans(t *testing.T) {
+	dir := t.TempDir()
+	// A file of another output sharing the directory
+	other := filepath.Join(dir, "n2p_script_other.prom")
+	if err := ioutil.WriteFile(other, []byte("n2p_script_exec_other 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := config.Output{Name: "split", Type: "textfile", Directory: dir, SplitBy: "script", FileMode: "0644"}
+	scripts := []config.Script{
+		{Name: "load", Path: "/scripts/check_load"},
+		{Path: "/scripts/check-disk.sh"},
+	}
+	metrics := []lib.Metric{
+		{Name: "load", Value: 1, Source: "/scripts/check_load"},
+		{Name: "disk", Value: 2, Source: "/scripts/check-disk.sh"},
+		{Name: "last_execution", Value: 3},
+	}
+	s, err := NewTextfileSink(out, scripts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(metrics); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"n2p_executor.prom", "n2p_script_check_disk.prom", "n2p_script_load.prom", "n2p_script_other.prom"}
+	if got := promFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+
+	// The script check_load is removed from the config by the next execution
+	s, _ = NewTextfileSink(out, scripts[1:])
+	if err := s.Write(metrics[1:]); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"n2p_executor.prom", "n2p_script_check_disk.prom", "n2p_script_other.prom"}
+	if got := promFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("the file of the other output was removed: %v", err)
+	}
+}
+
+func TestTextfileSplitByGroup(t *testing.T) {
+	dir := t.TempDir()
+	out := config.Output{Name: "split", Type: "textfile", Directory: dir, SplitBy: "group", FileMode: "0640"}
+	scripts := []config.Script{
+		{Path: "/scripts/a", Group: "db"},
+		{Path: "/scripts/b", Group: "db"},
+		{Path: "/scripts/c"},
+	}
+	s, err := NewTextfileSink(out, scripts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Write([]lib.Metric{
+		{Name: "a", Value: 1, Source: "/scripts/a"},
+		{Name: "b", Value: 1, Source: "/scripts/b"},
+		{Name: "c", Value: 1, Source: "/scripts/c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"n2p_group_db.prom", "n2p_group_default.prom"}
+	if got := promFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "n2p_group_db.prom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, want 0640", fi.Mode().Perm())
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, "n2p_group_db.prom"))
+	if got := string(content); got != lib.GenerateSeries([]lib.Metric{{Name: "a", Value: 1}, {Name: "b", Value: 1}}) {
+		t.Errorf("unexpected content of the db group file:\n%s", got)
+	}
+}